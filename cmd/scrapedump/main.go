@@ -2,7 +2,6 @@ package main
 
 import (
 	//"bytes"
-	"encoding/hex"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -11,7 +10,8 @@ import (
 	"strings"
 	"time"
 
-	"github.com/anacrolix/torrent/bencode"
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+	tp "github.com/torrentdb/torrent_utils/lib/torrentparse"
 )
 
 type argsStruct struct {
@@ -38,7 +38,7 @@ type statsStruct struct {
 	band_100_999   int
 	band_1000_9999 int
 	band_10000_inf int
-	val	       int
+	val            int
 }
 
 var args argsStruct
@@ -81,9 +81,11 @@ func main() {
 	var leechStats statsStruct
 
 	// filter and print scrape entries
-	for infoHash, item := range scrape.Files {
+	for rawHash, item := range scrape.Files {
 
-		infoHash = hex.EncodeToString([]byte(infoHash))
+		var hash tp.InfoHash
+		copy(hash[:], rawHash)
+		infoHash := hash.String()
 
 		updateStats(infoHash, item, &seedStats, "seeders")
 		updateStats(infoHash, item, &downStats, "downloaded")
@@ -101,7 +103,7 @@ func main() {
 			continue
 		}
 
-		if infoHash == "0000000000000000000000000000000000000000" {
+		if hash.IsZero() {
 			continue
 		}
 
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	tp "github.com/torrentdb/torrent_utils/lib/torrentparse"
+)
+
+// rejections with control characters in names and paths are logged on one
+// line of error.log and read back by the export
+func TestErrorLog(t *testing.T) {
+
+	const hash = "d3387a55e17b78e45251cd33fd8e45e21946e41a"
+
+	tests := []struct {
+		logmsg  string
+		err     error
+		torrent string
+		reason  string
+	}{
+		{hash + " name", torrentIsValid(&tp.Info{Name: "bad\nname"}), hash, "name"},
+		{hash + " name", torrentIsValid(&tp.Info{Name: "n", Files: []tp.File{{Path: "n/a\r\x7fb"}}}),
+			hash, "name"},
+		{hash + " name", torrentIsValid(&tp.Info{Name: "bad\xffname"}), hash, "name"},
+		{"/tmp/a b\nc/" + hash + ".torrent decode", errors.New("torrentparse: decode\tfailed"),
+			`/tmp/a b\nc/` + hash + ".torrent", "decode"},
+	}
+
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", log.LstdFlags)
+	for _, test := range tests {
+		if test.err == nil {
+			t.Fatalf("%q: not rejected", test.logmsg)
+		}
+		logger.Println(errorLogMsg(test.logmsg, test.err))
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(tests) {
+		t.Fatalf("%d lines for %d rejections: %q", len(lines), len(tests), buf.String())
+	}
+
+	reasons := rejectionReasons()
+	for j, test := range tests {
+		_, torrent, reason, msg, ok := parseRejection(lines[j], reasons)
+		if !ok || torrent != test.torrent || reason != test.reason {
+			t.Errorf("%q: %v %q %q, want %q %q", lines[j], ok, torrent, reason, test.torrent, test.reason)
+		}
+		if strings.ContainsAny(msg, "\r\t\x7f") {
+			t.Errorf("%q: control characters in %q", lines[j], msg)
+		}
+	}
+
+	// lines of old logs without a date are skipped
+	if _, _, _, _, ok := parseRejection("name "+hash, reasons); ok {
+		t.Error("line without a date")
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	ts "github.com/torrentdb/torrent_utils/lib/torrentstore"
+)
+
+// files.tsv block: "hash: <hash>", a "<length>\t<path>" line per file and
+// "---"
+type filesBlock struct {
+	hash    string
+	line    int      // of the hash
+	raw     []string // lines of the block as they are in the file
+	lines   []string // of the files
+	lengths []int64
+	paths   []string
+	size    int64
+	err     error // malformed block
+}
+
+var hashRegexp = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// calls fn for the blocks of files.tsv in order, lines outside of blocks
+// are passed as blocks without hash
+func eachFilesBlock(fn func(filesBlock) error) error {
+
+	var b *filesBlock
+	err := eachLine(*args.dbdir+"/files.tsv", func(n int, l string) error {
+
+		switch {
+		case strings.HasPrefix(l, "hash: "):
+			if b != nil {
+				b.err = errors.New("block without end")
+				if err := fn(*b); err != nil {
+					return err
+				}
+			}
+			b = &filesBlock{hash: strings.TrimPrefix(l, "hash: "), line: n, raw: []string{l}}
+			return nil
+
+		case b == nil:
+			return fn(filesBlock{line: n, raw: []string{l},
+				err: fmt.Errorf("line outside of a block: %q", l)})
+		}
+
+		b.raw = append(b.raw, l)
+		if l == "---" {
+			err := fn(*b)
+			b = nil
+			return err
+		}
+
+		fields := strings.SplitN(l, "\t", 2)
+		length, err := strconv.ParseInt(fields[0], 10, 64)
+		if len(fields) != 2 || err != nil {
+			if b.err == nil {
+				b.err = fmt.Errorf("line %d: %q", n, l)
+			}
+			return nil
+		}
+		b.lines = append(b.lines, l)
+		b.lengths = append(b.lengths, length)
+		b.paths = append(b.paths, fields[1])
+		b.size += length
+
+		return nil
+	})
+
+	if err == nil && b != nil {
+		b.err = errors.New("block without end")
+		err = fn(*b)
+	}
+
+	return err
+}
+
+// fsck mode, checks that the files of the database agree, -r repairs the
+// files that can be derived from torrents.db
+func fsckMode() {
+
+	problems, fixable := 0, 0
+	report := func(format string, a ...interface{}) {
+		problems++
+		fmt.Printf("  "+format+"\n", a...)
+	}
+
+	checkRecord := func(where string, r ts.Record) {
+		switch {
+		case !hashRegexp.MatchString(r.Hash):
+			report("%s: invalid hash %q", where, r.Hash)
+		case r.FirstSeen > r.LastSeen:
+			report("%s: %s first seen %s after last seen %s",
+				where, r.Hash, r.FirstSeen, r.LastSeen)
+		}
+	}
+
+	fmt.Println("* checking torrents.tsv...")
+	var tsvLines []string
+	tsvProblems := 0
+	tsvRecords := make(map[string]ts.Record)
+	var records []ts.Record
+	err := eachLine(*args.dbdir+"/torrents.tsv", func(n int, l string) error {
+
+		tsvLines = append(tsvLines, l)
+		before := problems
+		defer func() {
+			tsvProblems += problems - before
+		}()
+
+		r, err := ts.ParseLine(l)
+		if err != nil {
+			report("torrents.tsv line %d: %v", n, err)
+			return nil
+		}
+		checkRecord(fmt.Sprint("torrents.tsv line ", n), r)
+		if _, exists := tsvRecords[r.Hash]; exists {
+			report("torrents.tsv line %d: duplicate hash %s", n, r.Hash)
+			return nil
+		}
+		tsvRecords[r.Hash] = r
+		records = append(records, r)
+
+		return nil
+	})
+	errExit(err)
+
+	var db *ts.Log
+	dbFile := *args.dbdir + "/torrents.db"
+	indexFile := *args.dbdir + "/torrents.idx"
+	needTSV, needIndex, needFiles := false, false, false
+
+	if pathExists(dbFile) {
+		fmt.Println("* checking torrents.db and torrents.idx...")
+		if *args.repair {
+			db = openStore()
+		} else {
+			db, err = ts.OpenLogReadOnly(dbFile)
+		}
+		if err != nil {
+			report("%v", err)
+			fmt.Println("* problems:", problems)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		// torrents.tsv is written from torrents.db
+		records = records[:0]
+		n := 0
+		errExit(db.Each(func(r ts.Record) error {
+			records = append(records, r)
+			checkRecord(fmt.Sprint("torrents.db record ", n+1), r)
+			if !needTSV && (n >= len(tsvLines) || tsvLines[n] != ts.FormatLine(r)) {
+				report("torrents.tsv line %d doesn't match torrents.db", n+1)
+				needTSV = true
+			}
+			n++
+			return nil
+		}))
+		if !needTSV && n != len(tsvLines) {
+			report("torrents.tsv has %d lines for %d records of torrents.db", len(tsvLines), n)
+			needTSV = true
+		}
+
+		if err := db.CheckIndex(indexFile); err != nil {
+			report("torrents.idx: %v", err)
+			needIndex = true
+		}
+	}
+
+	fmt.Println("* checking files.tsv...")
+	known := make(map[string]int, len(records)) // files.tsv blocks by hash
+	for _, r := range records {
+		known[r.Hash] = 0
+	}
+	// -r only drops blocks of unknown hashes and duplicates, blocks of the
+	// records are regenerated by rebuild
+	blocks := make(map[string]filesBlock, len(records)) // kept
+	err = eachFilesBlock(func(b filesBlock) error {
+
+		count, exists := known[b.hash]
+		kept := blocks[b.hash]
+		switch {
+		case !exists && b.err != nil:
+			report("files.tsv line %d: %s: %v", b.line, b.hash, b.err)
+		case !exists:
+			report("files.tsv line %d: %s isn't in the database", b.line, b.hash)
+		case count > 0 && (b.err != nil || kept.err == nil):
+			report("files.tsv line %d: %s has another block", b.line, b.hash)
+		case count > 0:
+			// the malformed block reported before is dropped for this one
+			fmt.Printf("  files.tsv line %d: %s has a block replacing the one of line %d\n",
+				b.line, b.hash, kept.line)
+			blocks[b.hash] = b
+		case b.err != nil:
+			report("files.tsv line %d: %s: %v", b.line, b.hash, b.err)
+			blocks[b.hash] = b
+		default:
+			blocks[b.hash] = b
+		}
+		if !exists || count > 0 {
+			needFiles = true
+			fixable++
+		}
+		if exists {
+			known[b.hash]++
+		}
+
+		return nil
+	})
+	errExit(err)
+
+	needRebuild := false
+	for _, r := range records {
+		b, exists := blocks[r.Hash]
+		switch {
+		case !exists:
+			report("files.tsv: no block of %s", r.Hash)
+		case b.err != nil:
+		case len(b.lines) != r.Files || b.size != r.Size:
+			report("files.tsv line %d: %s has %d files of %d bytes, %d files of %d bytes in torrents.tsv",
+				b.line, r.Hash, len(b.lines), b.size, r.Files, r.Size)
+		default:
+			continue
+		}
+		needRebuild = true
+	}
+
+	fmt.Println("* checking stats.txt...")
+	var lastStats string
+	errExit(eachLine(*args.dbdir+"/stats.txt", func(n int, l string) error {
+		if n > 1 {
+			lastStats = l
+		}
+		return nil
+	}))
+	if fields := strings.Split(lastStats, "\t"); len(fields) == 8 {
+		total, err := strconv.Atoi(strings.TrimSpace(fields[7]))
+		if err != nil || total != len(records) {
+			report("stats.txt: db total %q of the last scan, %d torrents", fields[7], len(records))
+		}
+	} else if len(records) > 0 {
+		report("stats.txt: no scan of the %d torrents", len(records))
+	}
+
+	// lines that don't match torrents.db are replaced
+	if needTSV {
+		fixable += tsvProblems + 1
+	}
+	if needIndex {
+		fixable++
+	}
+
+	repaired := 0
+	if *args.repair && db != nil && fixable > 0 {
+		fmt.Println("* repairing...")
+		if needTSV {
+			dumpTorrents(db)
+			fmt.Println("  torrents.tsv written from torrents.db")
+		}
+		if needIndex {
+			if pathExists(indexFile) {
+				errExit(os.Remove(indexFile))
+			}
+			errExit(db.WriteIndex(indexFile))
+			fmt.Println("  torrents.idx rebuilt")
+		}
+		if needFiles {
+			rewriteFiles(blocks)
+			fmt.Println("  files.tsv written without the blocks above")
+		}
+		repaired = fixable
+	}
+
+	fmt.Println("* problems:", problems)
+	if problems == 0 {
+		return
+	}
+	if repaired > 0 {
+		fmt.Println("* repaired:", repaired)
+	} else if fixable > 0 && db != nil {
+		fmt.Println("*", fixable, "can be repaired with -r")
+	}
+	if needRebuild {
+		fmt.Println("* blocks of files.tsv of the records are regenerated by rebuild")
+	}
+	if problems > repaired {
+		os.Exit(1)
+	}
+}
+
+// writes files.tsv with only the blocks given, as they are and in their
+// order
+func rewriteFiles(blocks map[string]filesBlock) {
+
+	f := newReplacement("files.tsv")
+	defer f.discard()
+
+	errExit(eachFilesBlock(func(b filesBlock) error {
+		if kept, exists := blocks[b.hash]; !exists || kept.line != b.line {
+			return nil
+		}
+		for _, l := range b.raw {
+			if _, err := fmt.Fprintln(f, l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	f.done()
+}
+
+func writeFilesBlock(w io.Writer, hash string, lines []string) error {
+
+	fmt.Fprintln(w, "hash:", hash)
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
+	}
+	_, err := fmt.Fprintln(w, "---")
+
+	return err
+}
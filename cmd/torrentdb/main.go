@@ -2,39 +2,51 @@ package main
 
 import (
 	"bufio"
-	"encoding/hex"
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"reflect"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 	"unicode/utf8"
 
 	tp "github.com/torrentdb/torrent_utils/lib/torrentparse"
+	ts "github.com/torrentdb/torrent_utils/lib/torrentstore"
 )
 
-// TODO
-// modify torrents.tsv in-place (don't overwrite them)
-
 type argsStruct struct {
-	tordir *string
-	dbdir  *string
+	tordir  *string
+	dbdir   *string
+	padding *bool
+	workers *int
+	sqlite  *string
+	repair  *bool
+	archive *bool
+	gzip    *bool
 }
 
-type lineStruct struct {
-	hash      string
-	size      int
-	files     int
-	firstSeen string
-	lastSeen  string
-	hits      int
-	name      string
+// torrent file parsed and validated by a worker, n is its position in the
+// list of files so the results are written in the same order
+type parsedStruct struct {
+	n      int
+	path   string
+	stat   os.FileInfo
+	m      *tp.MetaInfo
+	hash   string
+	from   *ts.Archive // the torrent of hash is read from it, not path
+	raw    []byte      // torrent file, kept when archiving
+	skip   bool        // not modified since the last scan
+	reason string      // of the rejection when err is set
+	logmsg string
+	err    error
 }
 
 type statsStruct struct {
@@ -45,21 +57,80 @@ type statsStruct struct {
 	countFiles    int
 	countPreTotal int
 	countRejected int
+	countArchived int
+	rejected      map[string]int
 }
 
 var args argsStruct
 var stats statsStruct
 
+// torrents accepted are stored in it with -a
+var archive *ts.Archive
+
+// modes besides scanning, given as the first argument
+var modes = map[string]func(){
+	"export":  exportMode,
+	"fsck":    fsckMode,
+	"index":   indexMode,
+	"rebuild": rebuildMode,
+}
+
+// results of parsing held at most per worker
+const jobsPerWorker = 4
+
+// torrents are only indexed, the info dict and piece hashes aren't needed
+var parseOpts = tp.ParseOptions{DiscardRaw: true}
+
 func init() {
 
-	args.tordir = flag.String("t", "", "dir with torsniff dir structure")
+	args.tordir = flag.String("t", "",
+		"dir with torsniff dir structure, rebuild also reads the dirs of the arguments")
 	args.dbdir = flag.String("d", "", "database dir")
+	args.padding = flag.Bool("p", false,
+		"include BEP 47 padding files in sizes, counts and files.tsv")
+	args.workers = flag.Int("w", runtime.NumCPU(), "number of parsing workers")
+	args.sqlite = flag.String("s", "",
+		"SQLite file written from the database after the scan, or by export")
+	args.repair = flag.Bool("r", false,
+		"fsck: repair torrents.tsv, torrents.idx and files.tsv from torrents.db")
+	args.archive = flag.Bool("a", false,
+		"store the torrent files accepted in the archive dir of the database")
+	args.gzip = flag.Bool("z", false, "compress the torrent files stored with -a")
 }
 
 func main() {
 
 	flag.Usage = printUsage
-	flag.Parse()
+
+	var mode func()
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		mode = modes[os.Args[1]]
+		if mode == nil {
+			printUsage()
+			os.Exit(2)
+		}
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+
+	if *args.workers < 1 || *args.dbdir == "" {
+		printUsage()
+		os.Exit(2)
+	}
+
+	recoverScan()
+
+	if *args.archive {
+		var err error
+		archive, err = ts.OpenArchive(archiveDir(), *args.gzip)
+		errExit(err)
+	}
+
+	if mode != nil {
+		mode()
+		return
+	}
 
 	stats.lastScanTime = getLastScan()
 	dt := time.Unix(stats.lastScanTime, 0)
@@ -72,134 +143,216 @@ func main() {
 
 	stats.countFiles = len(torrentFiles)
 
-	fmt.Println("* loading torrents.tsv into memory...")
-	hashList, indexList := loadTorrents()
+	fmt.Println("* loading torrents.db into memory...")
+	db := openStore()
+	defer db.Close()
+
+	stats.countPreTotal = db.Len()
+
+	tx := newScanTx(db)
 
-	fmt.Println("* parsing torrent files, updating the buffers and dumping torrent files...")
-	processFiles(torrentFiles, hashList, indexList)
+	fmt.Println("* parsing torrent files, updating the records and dumping torrent files...")
+	processFiles(torrentFiles, db, tx)
+	dumpStats(tx)
+	dumpRejections(tx)
 
-	stats.countPreTotal = len(hashList)
+	if archive != nil {
+		fmt.Println("* torrent files archived:", stats.countArchived)
+	}
+
+	fmt.Println("* committing the scan...")
+	// the archive is synced first, torrents archived by a scan that is
+	// rolled back are left in it
+	if archive != nil {
+		errExit(archive.Sync())
+	}
+	tx.commit()
+	errExit(db.WriteIndex(*args.dbdir + "/torrents.idx"))
 
-	fmt.Println("* dumping torrent.tsv...")
-	//dumpTorrents(torrents, newTorrents )
-	dumpStats()
+	fmt.Println("* dumping torrents.tsv...")
+	dumpTorrents(db)
+	tx.finish()
+
+	if *args.sqlite != "" {
+		fmt.Println("* exporting the database to", *args.sqlite, "...")
+		errExit(exportSQLite(db, *args.sqlite))
+	}
 }
 
-func processFiles(torrentFiles []string, hashList []string, indexList []int64) {
+func processFiles(torrentFiles []string, db ts.Store, tx *scanTx) {
 
 	newTorrentsCheck := make(map[string]bool)
-	var newTorrents []string
 
-	torrentsFile := *args.dbdir + "/torrents.tsv"
-	fTorrents, err := os.OpenFile(torrentsFile, os.O_CREATE|os.O_RDWR, 0644)
-	defer fTorrents.Close()
-	errExit(err)
+	fFiles := tx.file("files.tsv")
+	fMeta := tx.file("meta.tsv")
+	fWarnings := tx.file("warnings.tsv")
 
-	filesFile := *args.dbdir + "/files.tsv"
-	fFiles, err := os.OpenFile(filesFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	defer fFiles.Close()
-	errExit(err)
-
-	for _, torrentFile := range torrentFiles {
+	parsed := make([]parsedStruct, len(torrentFiles))
+	for n, torrentFile := range torrentFiles {
+		parsed[n].path = torrentFile
+	}
 
-		stat, _ := os.Stat(torrentFile)
-		mTime := stat.ModTime().Unix()
-		if mTime < stats.lastScanTime || mTime > stats.scanTime {
+	for p := range parseFiles(parsed) {
+		if p.skip {
+			continue
+		}
+		if p.err != nil {
+			countRejected(p.reason)
+			logParseError(tx, p.logmsg, p.err)
 			continue
 		}
+		t := p.m.Info
 
-		f, err := os.Open(torrentFile)
-		errExit(err)
-		t, err := tp.ParseTorrent(f)
-		f.Close()
-		if err != nil {
-			stats.countRejected++
-			logParseError(f.Name(), err)
+		hash := t.HashStr
+
+		// skip new hashes from wrongly named torrent files
+		if _, exists := newTorrentsCheck[hash]; exists {
 			continue
 		}
 
-		hash := hex.EncodeToString(t.Hash[:])
+		archiveTorrent(hash, p.raw)
 
-		err = torrentIsValid(t)
-		if err != nil {
-			stats.countRejected++
-			logParseError(hash, err)
+		if r, exists := db.Get(hash); exists {
+			updateRecord(db, r, p.stat)
 			continue
 		}
 
-		hashID := sort.SearchStrings(hashList, hash)
+		r := torrentToRecord(t, p.stat)
+		errExit(db.Put(r))
+		newTorrentsCheck[hash] = true
 
-		if hashID > len(hashList)-1 || hashList[hashID] != hash {
+		dumpTFiles(fFiles, r, t)
+		dumpMeta(fMeta, r, p.m)
+		dumpWarnings(fWarnings, r, p.m)
+		stats.countNew++
+	}
+}
 
-			line := torrentToLine(t, stat)
+// parses and validates the torrent files with a pool of workers, the path
+// of each is set or its hash with the archive it's read from
+//
+// Results come in the order of the files. Files are only handed to the
+// workers while fewer than jobsPerWorker results per worker are waiting,
+// so one slow file doesn't hold the rest of the scan in memory.
+func parseFiles(torrentFiles []parsedStruct) <-chan parsedStruct {
+
+	filesCh := make(chan parsedStruct)
+	parsedCh := make(chan parsedStruct)
+	orderedCh := make(chan parsedStruct)
+	tokens := make(chan struct{}, jobsPerWorker**args.workers)
+	wg := new(sync.WaitGroup)
+
+	for w := 1; w <= *args.workers; w++ {
+		wg.Add(1)
+		go parseFile(filesCh, parsedCh, wg)
+	}
 
-			// skip new hashes from wrongly named torrent files
-			if _, exists := newTorrentsCheck[hash]; exists {
-				continue
+	go func() {
+		for n, p := range torrentFiles {
+			tokens <- struct{}{}
+			p.n = n
+			filesCh <- p
+		}
+		close(filesCh)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(parsedCh)
+	}()
+
+	go func() {
+		pending := make(map[int]parsedStruct)
+		next := 0
+		for p := range parsedCh {
+			pending[p.n] = p
+			for {
+				p, exists := pending[next]
+				if !exists {
+					break
+				}
+				delete(pending, next)
+				next++
+				orderedCh <- p
+				<-tokens
 			}
+		}
+		close(orderedCh)
+	}()
+
+	return orderedCh
+}
 
-			newTorrents = append(newTorrents, printLine(line))
-			newTorrentsCheck[hash] = true
+func parseFile(filesCh <-chan parsedStruct, parsedCh chan<- parsedStruct,
+	wg *sync.WaitGroup) {
 
-			dumpTFiles(fFiles, line, t)
-			stats.countNew++
+	defer wg.Done()
+
+	for p := range filesCh {
+
+		p.stat, _ = os.Stat(p.path)
+		mTime := p.stat.ModTime().Unix()
+		if mTime < stats.lastScanTime || mTime > stats.scanTime {
+			p.skip = true
+			parsedCh <- p
+			continue
+		}
+
+		var b []byte
+		var err error
+		if p.from != nil {
+			b, err = p.from.Get(p.hash)
 		} else {
-			updateLine(fTorrents, indexList, hash, hashID, stat)
+			b, err = os.ReadFile(p.path)
+		}
+		errExit(err)
+		p.m, err = parseOpts.ParseMetaInfo(bytes.NewReader(b))
+		if err != nil {
+			p.reason = tp.ReasonOf(err)
+			p.logmsg = p.path + " " + p.reason
+			p.err = err
+			parsedCh <- p
+			continue
 		}
-	}
 
-	_, err = fTorrents.Seek(0, 2)
-	errExit(err)
-	for _, l := range newTorrents {
-		fmt.Fprintln(fTorrents, l)
-	}
+		err = torrentIsValid(p.m.Info)
+		if err != nil {
+			p.reason = "name"
+			p.logmsg = p.m.Info.HashStr + " " + p.reason
+			p.err = err
+		}
+		if p.err == nil && archive != nil {
+			p.raw = b
+		}
 
-	return
+		parsedCh <- p
+	}
 }
 
-func updateLine(fTorrents *os.File, indexList []int64, hash string, hashID int,
-	stat os.FileInfo) {
-
-	// get and modify the line
-	_, err := fTorrents.Seek(indexList[hashID], 0)
-	errExit(err)
+func archiveDir() string {
 
-	var line lineStruct
-	var partName string
-	_, err = fmt.Fscan(fTorrents,
-		&line.hash, &line.size, &line.files,
-		&line.firstSeen, &line.lastSeen, &line.hits,
-		&partName)
-	errExit(err)
+	return *args.dbdir + "/archive"
+}
 
-	line.hits++
-	line.lastSeen = stat.ModTime().Format("2006-01-02")
+// stores the torrent file in the archive with -a
+func archiveTorrent(hash string, torrent []byte) {
 
-	if line.hash != hash {
-		errExit(fmt.Errorf("modifing incorrect hash: %s - %s",
-			hash, line.hash))
+	if archive == nil {
+		return
 	}
 
-	// update then line
-	_, err = fTorrents.Seek(indexList[hashID], 0)
-	fmt.Fprint(fTorrents, printLine(line))
-
-	// check the line
-	_, err = fTorrents.Seek(indexList[hashID], 0)
+	stored, err := archive.Put(hash, torrent)
 	errExit(err)
+	if stored {
+		stats.countArchived++
+	}
+}
 
-	var lineMod lineStruct
-	var partNameMod string
-	_, err = fmt.Fscan(fTorrents,
-		&lineMod.hash, &lineMod.size, &lineMod.files,
-		&lineMod.firstSeen, &lineMod.lastSeen, &lineMod.hits,
-		&partNameMod)
-	errExit(err)
+func updateRecord(db ts.Store, r ts.Record, stat os.FileInfo) {
 
-	if !reflect.DeepEqual(line, lineMod) || partName != partNameMod {
-		errExit(fmt.Errorf("writing hash failed: %s - %s",
-			hash, line.hash))
-	}
+	r.Hits++
+	r.LastSeen = stat.ModTime().Format("2006-01-02")
+	errExit(db.Put(r))
 
 	stats.countUpdated++
 }
@@ -233,125 +386,139 @@ func getLastScan() int64 {
 	return lastScan
 }
 
-func loadTorrents() ([]string, []int64) {
+// opens torrents.db, reporting the uncommitted entries it drops
+func openLog() *ts.Log {
 
-	var hashList []string
-	var indexList []int64
-	var hashIndexList []string
-	var lineIndex int64
+	db, err := ts.OpenLog(*args.dbdir + "/torrents.db")
+	errExit(err)
+	if db.Dropped() > 0 {
+		fmt.Println("* dropped", db.Dropped(), "bytes of uncommitted entries of torrents.db")
+	}
 
-	inFile := *args.dbdir + "/torrents.tsv"
+	return db
+}
 
-	if !pathExists(inFile) {
+// opens torrents.db, databases made before it are imported from torrents.tsv
+func openStore() *ts.Log {
 
-		f, err := os.OpenFile(inFile, os.O_CREATE|os.O_RDONLY, 0644)
-		f.Close()
-		errExit(err)
+	db := openLog()
+
+	torrentsFile := *args.dbdir + "/torrents.tsv"
+	if db.Len() > 0 || !pathExists(torrentsFile) {
+		return db
 	}
 
-	f, err := os.Open(inFile)
-	defer f.Close()
+	f, err := os.Open(torrentsFile)
 	errExit(err)
+	defer f.Close()
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-
-		l := scanner.Text()
-		iStr := strconv.FormatInt(lineIndex, 10)
-
-		hashIndex := strings.Split(l, "\t")[0] + "\t" + iStr
-		hashIndexList = append(hashIndexList, hashIndex)
-		lineIndex += int64(len(l)) + 1
+	n, err := ts.ImportTSV(f, db)
+	errExit(err)
+	errExit(db.Commit())
+	if n > 0 {
+		fmt.Println("* imported", n, "lines of torrents.tsv")
 	}
 
-	sort.Strings(hashIndexList)
+	return db
+}
 
-	for _, hashIndex := range hashIndexList {
+// index mode, rebuilds torrents.idx from torrents.db
+func indexMode() {
 
-		hi := strings.Split(hashIndex, "\t")
-		hash := hi[0]
-		index, err := strconv.ParseInt(hi[1], 10, 64)
-		errExit(err)
+	db := openStore()
+	defer db.Close()
 
-		if len(hash) != 40 || index < 0 {
-			errExit(fmt.Errorf("incorrect hash and index: %s - %s",
-				hash, index))
-		}
-
-		hashList = append(hashList, hash)
-		indexList = append(indexList, index)
+	indexFile := *args.dbdir + "/torrents.idx"
+	if pathExists(indexFile) {
+		errExit(os.Remove(indexFile))
 	}
 
-	return hashList, indexList
+	fmt.Println("* writing torrents.idx...")
+	errExit(db.WriteIndex(indexFile))
 }
 
-func parseLine(l string) lineStruct {
+// writes torrents.tsv through a temporary file, so readers never see it
+// half-written
+func dumpTorrents(db ts.Store) {
 
-	var line lineStruct
-	var err error
+	f := newReplacement("torrents.tsv")
+	defer f.discard()
 
-	ll := strings.Split(l, "\t")
+	errExit(ts.WriteTSV(f, db))
+	f.done()
+}
 
-	line.hash = ll[0]
+// temporary file in dbdir that replaces the file of the name when done
+type replacement struct {
+	*bufio.Writer
+	f    *os.File
+	name string
+}
 
-	line.size, err = strconv.Atoi(strings.TrimSpace(ll[1]))
-	errExit(err)
+func newReplacement(name string) *replacement {
 
-	line.files, err = strconv.Atoi(strings.TrimSpace(ll[2]))
+	f, err := os.CreateTemp(*args.dbdir, "."+name+"-")
 	errExit(err)
 
-	line.firstSeen = strings.TrimSpace(ll[3])
-	line.lastSeen = strings.TrimSpace(ll[4])
+	return &replacement{Writer: bufio.NewWriter(f), f: f, name: name}
+}
 
-	line.hits, err = strconv.Atoi(strings.TrimSpace(ll[5]))
-	errExit(err)
+func (r *replacement) done() {
 
-	line.name = strings.TrimSpace(ll[6])
+	err := r.Flush()
+	if err == nil {
+		err = r.f.Sync()
+	}
+	if err == nil {
+		err = r.f.Chmod(0644)
+	}
+	if closeErr := r.f.Close(); err == nil {
+		err = closeErr
+	}
+	errExit(err)
 
-	return line
+	errExit(os.Rename(r.f.Name(), *args.dbdir+"/"+r.name))
+	errExit(ts.SyncDir(*args.dbdir))
 }
 
-func torrentToLine(t *tp.Info, stat os.FileInfo) lineStruct {
-
-	var line lineStruct
-	mtime := stat.ModTime().Format("2006-01-02")
-
-	line.hash = hex.EncodeToString(t.Hash[:])
-	line.size = int(t.Length)
-	line.files = len(t.Files)
-	line.firstSeen = mtime
-	line.lastSeen = mtime
-	line.hits = 1
-	line.name = t.Name
+// removes the temporary file unless it replaced the file
+func (r *replacement) discard() {
 
-	return line
+	r.f.Close()
+	os.Remove(r.f.Name())
 }
 
-func printLine(line lineStruct) string {
+func torrentToRecord(t *tp.Info, stat os.FileInfo) ts.Record {
 
-	l := fmt.Sprintf("%s\t%14d\t%11d\t%s\t%s\t%5d\t%s",
-		line.hash,
-		line.size,
-		line.files,
-		line.firstSeen,
-		line.lastSeen,
-		line.hits,
-		line.name)
+	var r ts.Record
+	mtime := stat.ModTime().Format("2006-01-02")
 
-	return l
+	r.Hash = t.HashStr
+	r.Size = t.ContentLength()
+	r.Files = len(t.ContentFiles())
+	if *args.padding {
+		r.Size = t.Length
+		r.Files = len(t.Files)
+	}
+	r.FirstSeen = mtime
+	r.LastSeen = mtime
+	r.Hits = 1
+	r.Name = t.Name
+
+	return r
 }
 
 func torrentIsValid(t *tp.Info) error {
 
 	if !utf8.Valid([]byte(t.Name)) {
 
-		return fmt.Errorf("non UTF-8 char in name: %s", t.Name)
+		return fmt.Errorf("non UTF-8 char in name: %q", t.Name)
 	}
 
 	isAllowed, c, i := stringIsAllowed(t.Name)
 	if !isAllowed {
 
-		return fmt.Errorf("not allowed char %d: 0x%0.2x %U; in name: %s",
+		return fmt.Errorf("not allowed char %d: 0x%0.2x %U; in name: %q",
 			i+1, c, c, t.Name)
 	}
 
@@ -359,13 +526,13 @@ func torrentIsValid(t *tp.Info) error {
 
 		if !utf8.Valid([]byte(f.Path)) {
 
-			return fmt.Errorf("non UTF-8 char in filename: %s", f.Path)
+			return fmt.Errorf("non UTF-8 char in filename: %q", f.Path)
 		}
 
 		isAllowed, c, i := stringIsAllowed(f.Path)
 		if !isAllowed {
 
-			return fmt.Errorf("not allowed char %d: 0x%0.2x %U; in filename: %s",
+			return fmt.Errorf("not allowed char %d: 0x%0.2x %U; in filename: %q",
 				i+1, c, c, f.Path)
 		}
 	}
@@ -385,26 +552,75 @@ func stringIsAllowed(s string) (bool, rune, int) {
 	return true, '0', 0
 }
 
-func dumpTFiles(fFiles *os.File, line lineStruct, t *tp.Info) {
+func dumpTFiles(fFiles io.Writer, r ts.Record, t *tp.Info) {
 
-	fmt.Fprintln(fFiles, "hash:", line.hash)
-	for _, tFile := range t.Files {
+	files := t.ContentFiles()
+	if *args.padding {
+		files = t.Files
+	}
+
+	fmt.Fprintln(fFiles, "hash:", r.Hash)
+	for _, tFile := range files {
 
 		fmt.Fprintf(fFiles, "%d\t%s\n", tFile.Length, tFile.Path)
 	}
 	fmt.Fprintln(fFiles, "---")
 }
 
-func dumpStats() {
+// meta.tsv: hash, private, creation date, source, created by, trackers, comment
+func dumpMeta(fMeta io.Writer, r ts.Record, m *tp.MetaInfo) {
+
+	var created string
+	if !m.CreationDate.IsZero() {
+		created = m.CreationDate.Format("2006-01-02")
+	}
+
+	private := 0
+	if m.Info.Private {
+		private = 1
+	}
+
+	fmt.Fprintf(fMeta, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+		r.Hash,
+		private,
+		created,
+		cleanField(m.Info.Source),
+		cleanField(m.CreatedBy),
+		cleanField(strings.Join(m.TrackerList(), " ")),
+		cleanField(m.Comment))
+}
+
+// warnings.tsv: hash, kind, field, offset, detail
+func dumpWarnings(fWarnings io.Writer, r ts.Record, m *tp.MetaInfo) {
+
+	for _, w := range m.Warnings {
+		fmt.Fprintf(fWarnings, "%s\t%s\t%s\t%d\t%s\n",
+			r.Hash,
+			w.Kind,
+			cleanField(w.Field),
+			w.Offset,
+			cleanField(w.Detail))
+	}
+}
+
+// replaces tabs, new lines and other control chars with spaces
+func cleanField(s string) string {
+
+	return strings.Map(func(c rune) rune {
+		if unicode.IsControl(c) {
+			return ' '
+		}
+		return c
+	}, s)
+}
+
+func dumpStats(tx *scanTx) {
 
 	statsFile := *args.dbdir + "/stats.txt"
+	f := tx.file("stats.txt")
 
 	if !pathExists(statsFile) {
 
-		f, err := os.OpenFile(statsFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-		defer f.Close()
-		errExit(err)
-
 		fmt.Fprintf(f, "%s\t%s\t%18s\t%10s\t%10s\t%10s\t%10s\t%10s\n",
 			"scan unixtime",
 			"scan datetime",
@@ -416,10 +632,6 @@ func dumpStats() {
 			"db total")
 	}
 
-	f, err := os.OpenFile(statsFile, os.O_APPEND|os.O_WRONLY, 0644)
-	defer f.Close()
-	errExit(err)
-
 	scanTimeTime := time.Unix(stats.scanTime, 0)
 
 	fmt.Fprintf(f, "%d\t%s\t%10d\t%10d\t%10d\t%10d\t%10d\t%10d\n",
@@ -433,6 +645,38 @@ func dumpStats() {
 		stats.countPreTotal+stats.countNew)
 }
 
+func countRejected(reason string) {
+
+	if stats.rejected == nil {
+		stats.rejected = make(map[string]int)
+	}
+
+	stats.rejected[reason]++
+	stats.countRejected++
+}
+
+// rejections.tsv: scan unixtime, reason, count
+func dumpRejections(tx *scanTx) {
+
+	if len(stats.rejected) == 0 {
+		return
+	}
+
+	var reasons []string
+	for reason := range stats.rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	f := tx.file("rejections.tsv")
+
+	fmt.Println("* rejected torrents by reason:")
+	for _, reason := range reasons {
+		fmt.Printf("  %-14s %d\n", reason, stats.rejected[reason])
+		fmt.Fprintf(f, "%d\t%s\t%d\n", stats.scanTime, reason, stats.rejected[reason])
+	}
+}
+
 func pathExists(path string) bool {
 
 	_, err := os.Stat(path)
@@ -451,7 +695,15 @@ func pathExists(path string) bool {
 
 func printUsage() {
 
-	fmt.Printf("Usage: %s [options] <file.torrent>\n", os.Args[0])
+	fmt.Printf("Usage: %s [export|fsck|index|rebuild] [options]\n\n", os.Args[0])
+	fmt.Print("Scans the torrent dir into the database unless a mode is given.\n\n",
+		"modes:\n",
+		"  export\twrite the database to the SQLite file of -s\n",
+		"  fsck\tcheck that the files of the database agree\n",
+		"  index\trebuild the hash index torrents.idx\n",
+		"  rebuild\tparse again the torrents of the database from the archive and\n",
+		"  \tthe torrent dirs of -t and of the arguments, keeping hits and seen\n",
+		"  \tdates, -a stores the torrents found in the dirs in the archive\n\n")
 	flag.PrintDefaults()
 }
 
@@ -463,13 +715,28 @@ func errExit(err error) {
 	}
 }
 
-func logParseError(logmsg string, logerr error) {
+func logParseError(tx *scanTx, logmsg string, logerr error) {
 
-	errorFile := *args.dbdir + "/error.log"
-	f, err := os.OpenFile(errorFile, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
-	errExit(err)
-	defer f.Close()
+	tx.logger().Println(errorLogMsg(logmsg, logerr))
+}
+
+// the message is kept on one line of error.log, control characters of
+// paths and errors are escaped as in Go strings
+func errorLogMsg(logmsg string, logerr error) string {
+
+	s := logmsg + " " + logerr.Error()
+
+	var b strings.Builder
+	for len(s) > 0 {
+		c, size := utf8.DecodeRuneInString(s)
+		if unicode.IsControl(c) {
+			q := strconv.QuoteRune(c)
+			b.WriteString(q[1 : len(q)-1])
+		} else {
+			b.WriteString(s[:size])
+		}
+		s = s[size:]
+	}
 
-	log.SetOutput(f)
-	log.Println(logmsg, logerr)
+	return b.String()
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	ts "github.com/torrentdb/torrent_utils/lib/torrentstore"
+)
+
+// rebuild mode, parses again the torrent files of the records with the
+// current rules and regenerates the records, files.tsv, meta.tsv and
+// warnings.tsv from them
+//
+// Torrent files are read from the archive of the database, or looked up
+// by hash in the torsniff dirs of -t and of the remaining arguments. Hits
+// and seen dates are kept. Records without a torrent file, or whose
+// torrent is rejected now, are kept as they are. An interrupted rebuild is
+// finished by running it again.
+func rebuildMode() {
+
+	source := archive
+	if source == nil && pathExists(archiveDir()) {
+		var err error
+		source, err = ts.OpenArchive(archiveDir(), false)
+		errExit(err)
+	}
+
+	dirs := flag.Args()
+	if *args.tordir != "" {
+		dirs = append([]string{*args.tordir}, dirs...)
+	}
+	if len(dirs) == 0 && source == nil {
+		printUsage()
+		errExit(fmt.Errorf("no archive or torrent dir to rebuild from"))
+	}
+
+	if len(dirs) > 0 {
+		fmt.Println("* finding all torrent files in the directories...")
+	}
+	torrentPaths := make(map[string]string) // by the hash of the file name
+	for _, dir := range dirs {
+		torrentFiles, err := filepath.Glob(dir + "/*/*/*.torrent")
+		errExit(err)
+		for _, torrentFile := range torrentFiles {
+			hash := strings.TrimSuffix(filepath.Base(torrentFile), ".torrent")
+			if _, exists := torrentPaths[hash]; !exists {
+				torrentPaths[hash] = torrentFile
+			}
+		}
+	}
+
+	fmt.Println("* loading torrents.db into memory...")
+	db := openStore()
+	defer db.Close()
+
+	var records []ts.Record
+	var torrentFiles []parsedStruct
+	var parsedRecords []int // record of each torrent file
+	errExit(db.Each(func(r ts.Record) error {
+		p := parsedStruct{path: torrentPaths[r.Hash]}
+		if source != nil {
+			path, archived, err := source.Path(r.Hash)
+			errExit(err)
+			if archived {
+				p = parsedStruct{path: path, from: source, hash: r.Hash}
+			}
+		}
+		if p.path != "" {
+			torrentFiles = append(torrentFiles, p)
+			parsedRecords = append(parsedRecords, len(records))
+		}
+		records = append(records, r)
+		return nil
+	}))
+
+	// the lines of the records that are kept
+	blocks := make(map[string]filesBlock, len(records))
+	errExit(eachFilesBlock(func(b filesBlock) error {
+		if _, exists := blocks[b.hash]; !exists && b.err == nil {
+			blocks[b.hash] = b
+		}
+		return nil
+	}))
+	metaLines := hashLines("meta.tsv")
+	warningLines := hashLines("warnings.tsv")
+
+	fFiles := newReplacement("files.tsv")
+	defer fFiles.discard()
+	fMeta := newReplacement("meta.tsv")
+	defer fMeta.discard()
+	fWarnings := newReplacement("warnings.tsv")
+	defer fWarnings.discard()
+
+	countRebuilt, countChanged, countMissing, countRejected := 0, 0, 0, 0
+	keep := func(r ts.Record) {
+		if b, exists := blocks[r.Hash]; exists {
+			errExit(writeFilesBlock(fFiles, r.Hash, b.lines))
+		}
+		for _, l := range metaLines[r.Hash] {
+			fmt.Fprintln(fMeta, l)
+		}
+		for _, l := range warningLines[r.Hash] {
+			fmt.Fprintln(fWarnings, l)
+		}
+	}
+
+	fmt.Println("* parsing torrent files and regenerating the records...")
+	stats.scanTime = time.Now().Unix()
+	next := 0 // record
+
+	for p := range parseFiles(torrentFiles) {
+		for ; next < parsedRecords[p.n]; next++ {
+			keep(records[next])
+			countMissing++
+		}
+		old := records[next]
+		next++
+
+		switch {
+		case p.skip:
+			p.err = fmt.Errorf("modified after the rebuild started")
+		case p.err == nil && p.m.Info.HashStr != old.Hash:
+			p.err = fmt.Errorf("torrent file of %s", p.m.Info.HashStr)
+		}
+		if p.err != nil {
+			fmt.Printf("  %s kept: %s: %v\n", old.Hash, p.path, p.err)
+			keep(old)
+			countRejected++
+			continue
+		}
+
+		r := torrentToRecord(p.m.Info, p.stat)
+		r.FirstSeen, r.LastSeen, r.Hits = old.FirstSeen, old.LastSeen, old.Hits
+		if r != old {
+			errExit(db.Put(r))
+			countChanged++
+		}
+
+		archiveTorrent(r.Hash, p.raw)
+		dumpTFiles(fFiles, r, p.m.Info)
+		dumpMeta(fMeta, r, p.m)
+		dumpWarnings(fWarnings, r, p.m)
+		countRebuilt++
+	}
+	for ; next < len(records); next++ {
+		keep(records[next])
+		countMissing++
+	}
+
+	if archive != nil {
+		fmt.Println("* torrent files archived:", stats.countArchived)
+		errExit(archive.Sync())
+	}
+
+	fmt.Println("* committing the rebuild...")
+	fFiles.done()
+	fMeta.done()
+	fWarnings.done()
+	errExit(db.Commit())
+	errExit(db.WriteIndex(*args.dbdir + "/torrents.idx"))
+
+	fmt.Println("* dumping torrents.tsv...")
+	dumpTorrents(db)
+
+	fmt.Println("* rebuilt:", countRebuilt, "changed:", countChanged)
+	fmt.Println("* kept, no torrent file:", countMissing, "rejected:", countRejected)
+}
+
+// lines of a tsv file of the database by their first field, the hash
+func hashLines(name string) map[string][]string {
+
+	lines := make(map[string][]string)
+	errExit(eachLine(*args.dbdir+"/"+name, func(n int, l string) error {
+		hash := strings.SplitN(l, "\t", 2)[0]
+		lines[hash] = append(lines[hash], l)
+		return nil
+	}))
+
+	return lines
+}
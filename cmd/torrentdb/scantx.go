@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+	ts "github.com/torrentdb/torrent_utils/lib/torrentstore"
+)
+
+// A scan is a transaction: what it appends to the files of the database is
+// staged in scan.staging and appended when the scan commits. The journal
+// written before the appends has the sizes of the files and of torrents.db
+// before the scan, the commit of torrents.db after the appends is the
+// commit of the scan. The journal is removed once torrents.tsv and the index
+// are written from torrents.db. An interrupted scan is rolled back by
+// truncating the files when torrents.db wasn't committed, and completed
+// otherwise.
+type scanTx struct {
+	db       *ts.Log
+	files    map[string]*os.File // staged, by the name of the file in dbdir
+	errorLog *log.Logger
+}
+
+type journalStruct struct {
+	Generation uint64           `bencode:"generation"`
+	LogSize    int64            `bencode:"log_size"`
+	Sizes      map[string]int64 `bencode:"sizes"` // -1 for files that didn't exist
+}
+
+func stagingDir() string {
+
+	return *args.dbdir + "/scan.staging"
+}
+
+func journalFile() string {
+
+	return *args.dbdir + "/scan.journal"
+}
+
+func newScanTx(db *ts.Log) *scanTx {
+
+	errExit(os.RemoveAll(stagingDir()))
+	errExit(os.Mkdir(stagingDir(), 0755))
+
+	return &scanTx{db: db, files: make(map[string]*os.File)}
+}
+
+// staged file appended to the file of the name when the scan commits
+func (tx *scanTx) file(name string) *os.File {
+
+	if f, exists := tx.files[name]; exists {
+		return f
+	}
+
+	f, err := os.OpenFile(stagingDir()+"/"+name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	errExit(err)
+	tx.files[name] = f
+
+	return f
+}
+
+// error.log, with the dates and times of the log package
+func (tx *scanTx) logger() *log.Logger {
+
+	if tx.errorLog == nil {
+		tx.errorLog = log.New(tx.file("error.log"), "", log.LstdFlags)
+	}
+
+	return tx.errorLog
+}
+
+func (tx *scanTx) commit() {
+
+	var names []string
+	for name := range tx.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	j := journalStruct{Sizes: make(map[string]int64)}
+	j.Generation, j.LogSize = tx.db.Committed()
+
+	for _, name := range names {
+		f := tx.files[name]
+		errExit(f.Sync())
+		errExit(f.Close())
+
+		j.Sizes[name] = -1
+		if stat, err := os.Stat(*args.dbdir + "/" + name); err == nil {
+			j.Sizes[name] = stat.Size()
+		} else if !os.IsNotExist(err) {
+			errExit(err)
+		}
+	}
+
+	writeJournal(j)
+
+	for _, name := range names {
+		appendFile(*args.dbdir+"/"+name, stagingDir()+"/"+name)
+	}
+	errExit(ts.SyncDir(*args.dbdir))
+
+	errExit(tx.db.Commit())
+	errExit(os.RemoveAll(stagingDir()))
+}
+
+// ends the scan once the files written from torrents.db are up to date
+func (tx *scanTx) finish() {
+
+	errExit(os.Remove(journalFile()))
+	errExit(ts.SyncDir(*args.dbdir))
+}
+
+// writes the journal through a temporary file
+func writeJournal(j journalStruct) {
+
+	b, err := bencode.Marshal(j)
+	errExit(err)
+
+	f, err := os.CreateTemp(*args.dbdir, ".scan.journal-")
+	errExit(err)
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	errExit(err)
+
+	errExit(os.Rename(f.Name(), journalFile()))
+	errExit(ts.SyncDir(*args.dbdir))
+}
+
+func appendFile(path, stagedPath string) {
+
+	staged, err := os.Open(stagedPath)
+	errExit(err)
+	defer staged.Close()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	errExit(err)
+
+	_, err = io.Copy(f, staged)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	errExit(err)
+}
+
+// finishes a scan that was interrupted, before the database is used
+func recoverScan() {
+
+	if !pathExists(journalFile()) {
+		// nothing was appended yet
+		errExit(os.RemoveAll(stagingDir()))
+		return
+	}
+
+	b, err := os.ReadFile(journalFile())
+	errExit(err)
+	var j journalStruct
+	errExit(bencode.Unmarshal(b, &j))
+
+	db := openLog()
+	defer db.Close()
+
+	generation, size := db.Committed()
+	if generation == j.Generation && size == j.LogSize {
+		fmt.Println("* rolling back the interrupted scan...")
+		for name, size := range j.Sizes {
+			path := *args.dbdir + "/" + name
+			if size < 0 {
+				err = os.Remove(path)
+				if os.IsNotExist(err) {
+					err = nil
+				}
+			} else {
+				err = os.Truncate(path, size)
+			}
+			errExit(err)
+		}
+	} else {
+		fmt.Println("* completing the interrupted scan...")
+		dumpTorrents(db)
+		errExit(db.WriteIndex(*args.dbdir + "/torrents.idx"))
+	}
+
+	errExit(os.Remove(journalFile()))
+	errExit(os.RemoveAll(stagingDir()))
+}
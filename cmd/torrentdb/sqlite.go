@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tp "github.com/torrentdb/torrent_utils/lib/torrentparse"
+	ts "github.com/torrentdb/torrent_utils/lib/torrentstore"
+
+	// pure Go, builds without cgo
+	_ "modernc.org/sqlite"
+)
+
+// tables mirror torrents.tsv, files.tsv, stats.txt, error.log and
+// rejections.tsv
+const sqliteSchema = `
+CREATE TABLE torrents (
+	hash       TEXT PRIMARY KEY,
+	size       INTEGER NOT NULL,
+	files      INTEGER NOT NULL,
+	first_seen TEXT NOT NULL,
+	last_seen  TEXT NOT NULL,
+	hits       INTEGER NOT NULL,
+	name       TEXT NOT NULL
+);
+CREATE TABLE files (
+	hash   TEXT NOT NULL,
+	length INTEGER NOT NULL,
+	path   TEXT NOT NULL
+);
+CREATE TABLE scans (
+	scan_time     INTEGER PRIMARY KEY,
+	scan_datetime TEXT NOT NULL,
+	new           INTEGER NOT NULL,
+	updated       INTEGER NOT NULL,
+	rejected      INTEGER NOT NULL,
+	processed     INTEGER NOT NULL,
+	files         INTEGER NOT NULL,
+	total         INTEGER NOT NULL
+);
+CREATE TABLE rejections (
+	logged  TEXT NOT NULL,
+	torrent TEXT NOT NULL,
+	reason  TEXT NOT NULL,
+	error   TEXT NOT NULL
+);
+CREATE TABLE rejection_counts (
+	scan_time INTEGER NOT NULL,
+	reason    TEXT NOT NULL,
+	count     INTEGER NOT NULL,
+	PRIMARY KEY (scan_time, reason)
+);
+`
+
+// created after the rows are inserted, which is faster
+const sqliteIndexes = `
+CREATE INDEX torrents_size ON torrents (size);
+CREATE INDEX torrents_files ON torrents (files);
+CREATE INDEX torrents_first_seen ON torrents (first_seen);
+CREATE INDEX torrents_last_seen ON torrents (last_seen);
+CREATE INDEX torrents_hits ON torrents (hits);
+CREATE INDEX files_hash ON files (hash);
+CREATE INDEX files_length ON files (length);
+CREATE INDEX rejections_torrent ON rejections (torrent);
+CREATE INDEX rejections_reason ON rejections (reason);
+
+CREATE VIRTUAL TABLE torrents_fts USING fts5 (name, content='torrents');
+INSERT INTO torrents_fts (torrents_fts) VALUES ('rebuild');
+CREATE VIRTUAL TABLE files_fts USING fts5 (path, content='files');
+INSERT INTO files_fts (files_fts) VALUES ('rebuild');
+`
+
+// export mode, writes the SQLite file of -s without scanning
+func exportMode() {
+
+	if *args.sqlite == "" {
+		printUsage()
+		os.Exit(2)
+	}
+
+	db, err := ts.OpenReadOnly(*args.dbdir)
+	errExit(err)
+	defer db.Close()
+
+	fmt.Println("* exporting the database to", *args.sqlite, "...")
+	errExit(exportSQLite(db, *args.sqlite))
+}
+
+// writes the database to a new SQLite file that replaces path when done
+func exportSQLite(db ts.Store, path string) error {
+
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	os.Remove(tmpPath)
+	defer os.Remove(tmpPath)
+
+	sdb, err := sql.Open("sqlite", tmpPath)
+	if err != nil {
+		return err
+	}
+	defer sdb.Close()
+
+	// the file is synced before it replaces path
+	_, err = sdb.Exec("PRAGMA journal_mode = OFF; PRAGMA synchronous = OFF;" + sqliteSchema)
+	if err != nil {
+		return err
+	}
+
+	tx, err := sdb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, insert := range []func(*sql.Tx) error{
+		func(tx *sql.Tx) error { return insertTorrents(tx, db) },
+		insertFiles,
+		insertScans,
+		insertRejections,
+		insertRejectionCounts,
+	} {
+		if err := insert(tx); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if _, err := sdb.Exec(sqliteIndexes); err != nil {
+		return err
+	}
+	if err := sdb.Close(); err != nil {
+		return err
+	}
+
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func insertTorrents(tx *sql.Tx, db ts.Store) error {
+
+	stmt, err := tx.Prepare("INSERT INTO torrents VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	return db.Each(func(r ts.Record) error {
+		_, err := stmt.Exec(r.Hash, r.Size, r.Files, r.FirstSeen, r.LastSeen, r.Hits, r.Name)
+		return err
+	})
+}
+
+func insertFiles(tx *sql.Tx) error {
+
+	stmt, err := tx.Prepare("INSERT INTO files VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	return eachFilesBlock(func(b filesBlock) error {
+
+		if b.err != nil {
+			return fmt.Errorf("files.tsv line %d: %w", b.line, b.err)
+		}
+
+		for i, path := range b.paths {
+			if _, err := stmt.Exec(b.hash, b.lengths[i], path); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// stats.txt: a header and a row per scan
+func insertScans(tx *sql.Tx) error {
+
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO scans VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	return eachLine(*args.dbdir+"/stats.txt", func(n int, l string) error {
+
+		fields := strings.Split(l, "\t")
+		if n == 1 || len(fields) != 8 {
+			return nil
+		}
+
+		values := []interface{}{0, fields[1], 0, 0, 0, 0, 0, 0}
+		for i, field := range fields {
+			if i == 1 {
+				continue
+			}
+			v, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+			if err != nil {
+				return fmt.Errorf("stats.txt line %d: %w", n, err)
+			}
+			values[i] = v
+		}
+
+		_, err := stmt.Exec(values...)
+		return err
+	})
+}
+
+// error.log lines are "<date> <time> <torrent file> <reason> <error>" for
+// torrents that don't parse and "<date> <time> <hash> name <error>" for
+// names rejected, logs of old scans have no reason or no "name"
+func insertRejections(tx *sql.Tx) error {
+
+	stmt, err := tx.Prepare("INSERT INTO rejections VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	reasons := rejectionReasons()
+	skipped := 0
+
+	err = eachLine(*args.dbdir+"/error.log", func(n int, l string) error {
+
+		logged, torrent, reason, msg, ok := parseRejection(l, reasons)
+		if !ok {
+			// e.g. lines of names with newlines, logged before they were escaped
+			if skipped == 0 {
+				fmt.Printf("  error.log line %d skipped: %q\n", n, l)
+			}
+			skipped++
+			return nil
+		}
+
+		_, err := stmt.Exec(logged, torrent, reason, msg)
+		return err
+	})
+	if skipped > 0 {
+		fmt.Println("* error.log lines skipped:", skipped)
+	}
+
+	return err
+}
+
+// reason codes of the lines of error.log
+func rejectionReasons() map[string]bool {
+
+	reasons := map[string]bool{"name": true}
+	for _, reason := range tp.Reasons() {
+		reasons[reason] = true
+	}
+
+	return reasons
+}
+
+// splits a line of error.log, false if it isn't one
+func parseRejection(l string, reasons map[string]bool) (logged, torrent, reason, msg string, ok bool) {
+
+	// log.LstdFlags
+	const dateTime = "2006/01/02 15:04:05"
+	if len(l) <= len(dateTime) || l[len(dateTime)] != ' ' {
+		return "", "", "", "", false
+	}
+	if _, err := time.Parse(dateTime, l[:len(dateTime)]); err != nil {
+		return "", "", "", "", false
+	}
+	logged, rest := l[:len(dateTime)], l[len(dateTime)+1:]
+
+	if i := strings.Index(rest, ".torrent "); i >= 0 {
+		torrent, msg = rest[:i+len(".torrent")], rest[i+len(".torrent "):]
+	} else if fields := strings.SplitN(rest, " ", 2); len(fields) == 2 &&
+		hashRegexp.MatchString(fields[0]) {
+		torrent, reason, msg = fields[0], "name", fields[1]
+	} else {
+		return "", "", "", "", false
+	}
+
+	if fields := strings.SplitN(msg, " ", 2); len(fields) == 2 && reasons[fields[0]] {
+		reason, msg = fields[0], fields[1]
+	}
+
+	return logged, torrent, reason, msg, true
+}
+
+// rejections.tsv: scan unixtime, reason, count
+func insertRejectionCounts(tx *sql.Tx) error {
+
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO rejection_counts VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	return eachLine(*args.dbdir+"/rejections.tsv", func(n int, l string) error {
+
+		fields := strings.Split(l, "\t")
+		if len(fields) != 3 {
+			return fmt.Errorf("rejections.tsv line %d: %q", n, l)
+		}
+
+		_, err := stmt.Exec(fields[0], fields[1], fields[2])
+		return err
+	})
+}
+
+// calls fn with the lines of the file numbered from 1, missing files have
+// no lines
+func eachLine(path string, fn func(int, string) error) error {
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		n++
+		if err := fn(n, scanner.Text()); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
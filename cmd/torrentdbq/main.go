@@ -11,6 +11,9 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+
+	tp "github.com/torrentdb/torrent_utils/lib/torrentparse"
+	ts "github.com/torrentdb/torrent_utils/lib/torrentstore"
 )
 
 type argsStruct struct {
@@ -19,6 +22,7 @@ type argsStruct struct {
 	unordered  bool
 	any        bool
 	exact      bool
+	padding    bool
 
 	minSize  int
 	maxSize  int
@@ -37,6 +41,10 @@ type argsStruct struct {
 	sortFiles     bool
 	sortFirstSeen bool
 	sortLastSeen  bool
+
+	magnet  bool
+	hash    string
+	torrent string
 }
 
 type lineStruct struct {
@@ -65,6 +73,7 @@ func init() {
 	flag.BoolVar(&args.unordered, "u", false, "")
 	flag.BoolVar(&args.any, "a", false, "")
 	flag.BoolVar(&args.exact, "r", false, "")
+	flag.BoolVar(&args.padding, "x", false, "")
 
 	flag.IntVar(&args.minSize, "s", 0, "")
 	flag.IntVar(&args.maxSize, "S", 999999999999, "")
@@ -83,6 +92,10 @@ func init() {
 	flag.BoolVar(&args.sortFiles, "3", false, "")
 	flag.BoolVar(&args.sortFirstSeen, "4", false, "")
 	flag.BoolVar(&args.sortLastSeen, "5", false, "")
+
+	flag.BoolVar(&args.magnet, "m", false, "")
+	flag.StringVar(&args.hash, "H", "", "")
+	flag.StringVar(&args.torrent, "T", "", "")
 }
 
 func main() {
@@ -90,6 +103,15 @@ func main() {
 	flag.Usage = printUsage
 	flag.Parse()
 
+	if args.hash != "" {
+		lookupHash(strings.ToLower(args.hash))
+		return
+	}
+	if args.torrent != "" {
+		writeTorrent(args.torrent)
+		return
+	}
+
 	// a map containing hashes with filenames where search string
 	// matched a filename
 	searchFileList := searchFiles()
@@ -102,6 +124,12 @@ func main() {
 	for _, index := range sortedIndexes {
 
 		line := results[index]
+
+		if args.magnet {
+			printMagnet(line)
+			continue
+		}
+
 		printLine(line)
 
 		for i, file := range searchFileList[line.hash].names {
@@ -110,15 +138,13 @@ func main() {
 				file)
 		}
 	}
-	fmt.Println("Results:", len(results))
+	if !args.magnet {
+		fmt.Println("Results:", len(results))
+	}
 }
 
 func searchTorrents(searchFileList map[string]filesStruct) []lineStruct {
 
-	fh, err := os.Open(flag.Arg(0) + "/torrents.tsv")
-	errExit(err)
-	defer fh.Close()
-
 	var results []lineStruct
 	linesCh := make(chan lineStruct)
 	resultsCh := make(chan lineStruct)
@@ -130,22 +156,23 @@ func searchTorrents(searchFileList map[string]filesStruct) []lineStruct {
 	}
 
 	go func() {
-		scanner := bufio.NewScanner(fh)
-		for scanner.Scan() {
+		err := eachRecord(func(r ts.Record) error {
 
-			line := parseLine(scanner.Text())
+			line := recordToLine(r)
 			line.size /= (1024 * 1024)
 
 			_, keyExists := searchFileList[line.hash]
 			if keyExists {
 				if skipNumOrDate(line) {
-					continue
+					return nil
 				}
 				results = append(results, line)
 			} else {
 				linesCh <- line
 			}
-		}
+			return nil
+		})
+		errExit(err)
 		close(linesCh)
 	}()
 
@@ -161,6 +188,29 @@ func searchTorrents(searchFileList map[string]filesStruct) []lineStruct {
 	return results
 }
 
+// calls fn for the records of the database, read one at a time with
+// torrents.idx or loaded without a usable index
+func eachRecord(fn func(ts.Record) error) error {
+
+	n := 0
+	err := ts.ScanRecords(flag.Arg(0), func(r ts.Record) error {
+		n++
+		return fn(r)
+	})
+	if err == nil || n > 0 {
+		return err
+	}
+
+	log.Print(err, ", loading the database")
+	db, err := ts.OpenReadOnly(flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Each(fn)
+}
+
 func searchFiles() map[string]filesStruct {
 
 	searchFileList := make(map[string]filesStruct)
@@ -198,6 +248,11 @@ func searchFiles() map[string]filesStruct {
 			} else {
 				s := strings.Split(l, "\t")
 				name := s[1]
+
+				// databases made before padding files were left out
+				if !args.padding && tp.IsPaddingPath(name) {
+					continue
+				}
 				size, err := strconv.Atoi(s[0])
 				errExit(err)
 
@@ -400,30 +455,57 @@ func sortResults(results []lineStruct) []int {
 	return sortedIndexes
 }
 
-func parseLine(l string) lineStruct {
-
-	var line lineStruct
-	var err error
+// prints the torrent with the hash, found with torrents.idx
+func lookupHash(hash string) {
 
-	ll := strings.Split(l, "\t")
+	r, found, err := ts.LookupRecord(flag.Arg(0), hash)
+	if err != nil {
+		// without a usable index the database is read through
+		log.Print(err, ", reading the database")
+		r, found, err = ts.FindRecord(flag.Arg(0), hash)
+		errExit(err)
+	}
 
-	line.hash = ll[0]
+	if !found {
+		if !args.magnet {
+			fmt.Println("Results: 0")
+		}
+		return
+	}
 
-	line.size, err = strconv.Atoi(strings.TrimSpace(ll[1]))
-	errExit(err)
+	line := recordToLine(r)
+	line.size /= (1024 * 1024)
 
-	line.files, err = strconv.Atoi(strings.TrimSpace(ll[2]))
-	errExit(err)
+	if args.magnet {
+		printMagnet(line)
+		return
+	}
+	printLine(line)
+	fmt.Println("Results: 1")
+}
 
-	line.firstSeen = strings.TrimSpace(ll[3])
-	line.lastSeen = strings.TrimSpace(ll[4])
+// writes the torrent file of the hash from the archive of torrentdb -a
+func writeTorrent(hash string) {
 
-	line.hits, err = strconv.Atoi(strings.TrimSpace(ll[5]))
+	archive, err := ts.OpenArchive(flag.Arg(0)+"/archive", false)
+	errExit(err)
+	b, err := archive.Get(hash)
+	errExit(err)
+	_, err = os.Stdout.Write(b)
 	errExit(err)
+}
 
-	line.name = strings.TrimSpace(ll[6])
+func recordToLine(r ts.Record) lineStruct {
 
-	return line
+	return lineStruct{
+		hash:      r.Hash,
+		size:      int(r.Size),
+		files:     r.Files,
+		firstSeen: r.FirstSeen,
+		lastSeen:  r.LastSeen,
+		hits:      r.Hits,
+		name:      r.Name,
+	}
 }
 
 func printLine(line lineStruct) {
@@ -438,6 +520,15 @@ func printLine(line lineStruct) {
 		line.name)
 }
 
+func printMagnet(line lineStruct) {
+
+	hash, err := tp.ParseInfoHash(line.hash)
+	errExit(err)
+
+	magnet := tp.Magnet{InfoHash: hash, Name: line.name}
+	fmt.Println(magnet.String())
+}
+
 func errExit(err error) {
 
 	if err != nil {
@@ -456,6 +547,7 @@ search string options:
 	-u	toggle search of unordered words in search string
 	-a	toggle search of any word in search string
 	-r	toggle regexp in search string, case sensitive
+	-x	toggle searching also in padding files
 
 numeric filters:
 	-s	min size in MB
@@ -478,5 +570,14 @@ sorting options (default is by hits)
 	-4	by first seen
 	-5	by last seen
 
+output options:
+	-m	print magnet links instead of the results table
+
+lookup:
+	-H	print the torrent with this infohash, other options but -m
+		are ignored, uses torrents.idx of torrentdb
+	-T	write the torrent file with this infohash to stdout, from the
+		archive of torrentdb -a
+
 `, os.Args[0])
 }
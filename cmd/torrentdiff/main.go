@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	tp "github.com/torrentdb/torrent_utils/lib/torrentparse"
+)
+
+type argsStruct struct {
+	padding bool
+	quiet   bool
+}
+
+var args argsStruct
+
+// pieces of two torrents found in both, by hash
+type contentStruct struct {
+	comparable   bool // same piece length, or files with v2 piece roots
+	samePieces   int  // same hash at the same offset
+	sharedPieces int  // pieces of b found anywhere in a
+	sameFiles    int  // files with the same v2 pieces root
+}
+
+func init() {
+
+	flag.BoolVar(&args.padding, "x", false, "")
+	flag.BoolVar(&args.quiet, "q", false, "")
+}
+
+func main() {
+
+	flag.Usage = printUsage
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	a := parse(flag.Arg(0))
+	b := parse(flag.Arg(1))
+
+	if diff(a, b) {
+		os.Exit(1)
+	}
+}
+
+func parse(path string) *tp.MetaInfo {
+
+	f, err := os.Open(path)
+	errExit(err)
+	defer f.Close()
+
+	m, err := tp.ParseMetaInfo(f)
+	errExit(err)
+
+	return m
+}
+
+// prints the differences, reports if the infohashes differ
+func diff(a, b *tp.MetaInfo) bool {
+
+	ia, ib := a.Info, b.Info
+
+	field("Hash", ia.HashStr, ib.HashStr)
+	if ia.HasV2() || ib.HasV2() {
+		field("HashV2", ia.HashV2, ib.HashV2)
+	}
+	field("Name", ia.Name, ib.Name)
+	field("MetaVersion", ia.MetaVersion, ib.MetaVersion)
+	field("Private", ia.Private, ib.Private)
+	field("Source", ia.Source, ib.Source)
+	field("Size", ia.Length, ib.Length)
+	field("PieceSize", ia.PieceLength, ib.PieceLength)
+	field("NumPieces", ia.NumPieces, ib.NumPieces)
+	field("Files", len(files(ia)), len(files(ib)))
+
+	diffFiles(ia, ib)
+	diffList("Tracker", a.TrackerList(), b.TrackerList())
+	diffList("WebSeed", a.URLList, b.URLList)
+
+	c := compareContent(ia, ib)
+	switch {
+	case ia.Hash == ib.Hash:
+		fmt.Print("Content\t\tidentical\n")
+	case !c.comparable && ia.PieceLength != ib.PieceLength:
+		fmt.Print("Content\t\tnot comparable, piece sizes differ\n")
+	case !c.comparable:
+		fmt.Print("Content\t\tnot comparable, v1 and v2 only\n")
+	default:
+		status := "different"
+		if c.samePieces > 0 || c.sharedPieces > 0 || c.sameFiles > 0 {
+			status = "shared"
+		}
+		fmt.Print("Content\t\t", status, "\n")
+		if ia.PieceLength == ib.PieceLength && ia.HasV1() && ib.HasV1() {
+			fmt.Print("SamePieces\t", c.samePieces, "\n",
+				"SharedPieces\t", c.sharedPieces, " of ", ib.NumPieces, "\n")
+		}
+		if c.sameFiles > 0 {
+			fmt.Print("SameFiles\t", c.sameFiles, "\n")
+		}
+	}
+
+	return ia.Hash != ib.Hash
+}
+
+// prints both values when they differ, the value otherwise
+func field(name string, a, b interface{}) {
+
+	sa, sb := fmt.Sprint(a), fmt.Sprint(b)
+
+	tabs := "\t\t"
+	if len(name) >= 8 {
+		tabs = "\t"
+	}
+
+	if sa == sb {
+		if !args.quiet {
+			fmt.Print(name, tabs, sa, "\n")
+		}
+		return
+	}
+
+	fmt.Print(name, tabs, sa, " -> ", sb, "\n")
+}
+
+// files of the torrent without padding files unless -x is set
+func files(i *tp.Info) []tp.File {
+
+	if args.padding {
+		return i.Files
+	}
+
+	return i.ContentFiles()
+}
+
+// path of the file below the torrent name, empty for single file torrents
+func relPath(i *tp.Info, f tp.File) string {
+
+	if len(i.Files) == 1 && !strings.ContainsRune(f.Path, filepath.Separator) {
+		return ""
+	}
+
+	parts := strings.SplitN(f.Path, string(filepath.Separator), 2)
+	if len(parts) < 2 {
+		return f.Path
+	}
+
+	return parts[1]
+}
+
+// files added (+), removed (-) and resized (~) matched by their paths below
+// the torrent names, so renamed torrents are compared by their contents
+func diffFiles(a, b *tp.Info) {
+
+	lengths := make(map[string]int64)
+	for _, f := range files(a) {
+		lengths[relPath(a, f)] = f.Length
+	}
+
+	var added, resized []string
+	inB := make(map[string]bool)
+	for _, f := range files(b) {
+		path := relPath(b, f)
+		inB[path] = true
+
+		length, exists := lengths[path]
+		switch {
+		case !exists:
+			added = append(added, fmt.Sprintf("+\t%d\t%s", f.Length, f.Path))
+		case length != f.Length:
+			resized = append(resized, fmt.Sprintf("~\t%d -> %d\t%s", length, f.Length, f.Path))
+		}
+	}
+
+	var removed []string
+	for _, f := range files(a) {
+		if !inB[relPath(a, f)] {
+			removed = append(removed, fmt.Sprintf("-\t%d\t%s", f.Length, f.Path))
+		}
+	}
+
+	for _, lines := range [][]string{removed, added, resized} {
+		for _, l := range lines {
+			fmt.Print("File\t\t", l, "\n")
+		}
+	}
+}
+
+// items only in a (-) or only in b (+)
+func diffList(name string, a, b []string) {
+
+	inA := make(map[string]bool, len(a))
+	for _, s := range a {
+		inA[s] = true
+	}
+	inB := make(map[string]bool, len(b))
+	for _, s := range b {
+		inB[s] = true
+	}
+
+	var lines []string
+	for _, s := range a {
+		if !inB[s] {
+			lines = append(lines, "-\t"+s)
+		}
+	}
+	for _, s := range b {
+		if !inA[s] {
+			lines = append(lines, "+\t"+s)
+		}
+	}
+	sort.SliceStable(lines, func(x, y int) bool {
+		return lines[x][0] == '-' && lines[y][0] == '+'
+	})
+
+	for _, l := range lines {
+		fmt.Print(name, "\t\t", l, "\n")
+	}
+}
+
+// pieces start at the same offsets only with the same piece length, v2
+// files are compared by their pieces roots regardless of it
+func compareContent(a, b *tp.Info) contentStruct {
+
+	c := contentStruct{comparable: a.HasV2() && b.HasV2()}
+
+	roots := make(map[[32]byte]bool)
+	for _, f := range a.Files {
+		if f.PiecesRoot != [32]byte{} {
+			roots[f.PiecesRoot] = true
+		}
+	}
+	for _, f := range b.Files {
+		if roots[f.PiecesRoot] {
+			c.sameFiles++
+		}
+	}
+
+	if a.PieceLength != b.PieceLength || !a.HasV1() || !b.HasV1() {
+		return c
+	}
+	c.comparable = true
+
+	hashes := make(map[string]bool, a.NumPieces)
+	for n := 0; n < int(a.NumPieces); n++ {
+		hashes[string(a.PieceHash(n))] = true
+	}
+
+	for n := 0; n < int(b.NumPieces); n++ {
+		hash := b.PieceHash(n)
+		if hashes[string(hash)] {
+			c.sharedPieces++
+		}
+		if bytes.Equal(hash, a.PieceHash(n)) {
+			c.samePieces++
+		}
+	}
+
+	return c
+}
+
+func errExit(err error) {
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func printUsage() {
+
+	fmt.Printf(`
+usage: %s [options] <a.torrent> <b.torrent>
+
+Values that differ are printed as "a -> b", files and trackers only in a
+with "-", only in b with "+" and files resized with "~". Exits with 1 when
+the infohashes differ.
+
+options:
+	-q	print only the differences
+	-x	include padding files
+
+`, os.Args[0])
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tp "github.com/torrentdb/torrent_utils/lib/torrentparse"
+)
+
+type listFlag []string
+
+type argsStruct struct {
+	output     string
+	trackers   listFlag
+	noTrackers bool
+	webSeeds   listFlag
+	noWebSeeds bool
+	comment    string
+	noComment  bool
+	source     string
+	private    bool
+	public     bool
+	changeHash bool
+	dryRun     bool
+	setComment bool
+	setSource  bool
+}
+
+var args argsStruct
+
+func (l *listFlag) String() string {
+
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(s string) error {
+
+	*l = append(*l, s)
+	return nil
+}
+
+func init() {
+
+	flag.StringVar(&args.output, "o", "", "")
+	flag.Var(&args.trackers, "t", "")
+	flag.BoolVar(&args.noTrackers, "T", false, "")
+	flag.Var(&args.webSeeds, "w", "")
+	flag.BoolVar(&args.noWebSeeds, "W", false, "")
+	flag.StringVar(&args.comment, "c", "", "")
+	flag.BoolVar(&args.noComment, "C", false, "")
+	flag.StringVar(&args.source, "s", "", "")
+	flag.BoolVar(&args.private, "P", false, "")
+	flag.BoolVar(&args.public, "U", false, "")
+	flag.BoolVar(&args.changeHash, "f", false, "")
+	flag.BoolVar(&args.dryRun, "n", false, "")
+}
+
+func main() {
+
+	flag.Usage = printUsage
+	flag.Parse()
+
+	// empty values of -c and -s are edits too
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "c":
+			args.setComment = true
+		case "s":
+			args.setSource = true
+		}
+	})
+
+	if flag.NArg() == 0 || (args.output != "" && flag.NArg() > 1) ||
+		(args.private && args.public) {
+		printUsage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		if err := editFile(path); err != nil {
+			log.Print(path, ": ", err)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// edits the torrent and writes it to the output or in place
+func editFile(path string) error {
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	m, err := tp.ParseMetaInfo(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	oldHash := m.Info.HashStr
+
+	if err := edit(m); err != nil {
+		return err
+	}
+
+	if m.Info.HashStr != oldHash {
+		log.Print(path, ": warning: infohash changes from ", oldHash, " to ", m.Info.HashStr)
+		if !args.changeHash && !args.dryRun {
+			return fmt.Errorf("not written, -f allows changing the infohash")
+		}
+	}
+
+	out, err := m.Encode()
+	if err != nil {
+		return err
+	}
+
+	output := args.output
+	if output == "" {
+		output = path
+	}
+
+	fmt.Print(output, "\t", m.Info.HashStr, "\n")
+
+	if args.dryRun || (output == path && bytes.Equal(out, b)) {
+		return nil
+	}
+
+	return writeFile(output, out)
+}
+
+func edit(m *tp.MetaInfo) error {
+
+	if args.noTrackers {
+		m.Announce = ""
+		m.AnnounceList = nil
+	}
+
+	// every -t is a tier, trackers of a tier are separated by commas
+	if len(args.trackers) > 0 {
+		tiers := m.Trackers()
+		for _, tier := range args.trackers {
+			tiers = append(tiers, strings.Split(tier, ","))
+		}
+		m.Announce = tiers[0][0]
+		m.AnnounceList = nil
+		if len(tiers) > 1 || len(tiers[0]) > 1 {
+			m.AnnounceList = tiers
+		}
+	}
+
+	if args.noWebSeeds {
+		m.URLList = nil
+	}
+	m.URLList = append(m.URLList, args.webSeeds...)
+
+	if args.noComment {
+		m.Comment = ""
+	}
+	if args.setComment {
+		m.Comment = args.comment
+	}
+
+	// edits of the info dict change the infohash
+	if args.private && !m.Info.Private {
+		if err := m.SetPrivate(true); err != nil {
+			return err
+		}
+	}
+	if args.public && m.Info.Private {
+		if err := m.SetPrivate(false); err != nil {
+			return err
+		}
+	}
+	if args.setSource && args.source != m.Info.Source {
+		var source interface{}
+		if args.source != "" {
+			source = args.source
+		}
+		if err := m.SetInfoField("source", source); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writes through a temporary file so a failed write keeps the original
+func writeFile(path string, b []byte) error {
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".torrentedit-")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+func printUsage() {
+
+	fmt.Printf(`
+usage: %s [options] <file.torrent>...
+
+Torrents are edited in place unless -o is given for a single torrent.
+Edits of the info dict change the infohash and need -f.
+
+options:
+	-o	output file
+	-t	add a tracker tier, trackers separated by commas, repeatable
+	-T	remove all trackers, before adding those of -t
+	-w	add a web seed URL, repeatable
+	-W	remove all web seeds, before adding those of -w
+	-c	set the comment
+	-C	remove the comment
+	-s	set the source, empty removes it, changes the infohash
+	-P	set the private flag, changes the infohash
+	-U	remove the private flag, changes the infohash
+	-f	allow edits changing the infohash
+	-n	dry run, print the infohashes without writing
+
+`, os.Args[0])
+}
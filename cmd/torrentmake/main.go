@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tp "github.com/torrentdb/torrent_utils/lib/torrentparse"
+)
+
+type listFlag []string
+
+type argsStruct struct {
+	output      string
+	name        string
+	pieceLength uint
+	trackers    listFlag
+	webSeeds    listFlag
+	comment     string
+	source      string
+	private     bool
+	noDate      bool
+	workers     int
+}
+
+var args argsStruct
+
+func (l *listFlag) String() string {
+
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(s string) error {
+
+	*l = append(*l, s)
+	return nil
+}
+
+func init() {
+
+	flag.StringVar(&args.output, "o", "", "")
+	flag.StringVar(&args.name, "n", "", "")
+	flag.UintVar(&args.pieceLength, "p", 0, "")
+	flag.Var(&args.trackers, "t", "")
+	flag.Var(&args.webSeeds, "w", "")
+	flag.StringVar(&args.comment, "c", "", "")
+	flag.StringVar(&args.source, "s", "", "")
+	flag.BoolVar(&args.private, "P", false, "")
+	flag.BoolVar(&args.noDate, "D", false, "")
+	flag.IntVar(&args.workers, "j", 0, "")
+}
+
+func main() {
+
+	flag.Usage = printUsage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		printUsage()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+
+	// in KiB, a power of two that fits the uint32 piece length in bytes
+	if args.pieceLength > math.MaxUint32/1024 || args.pieceLength&(args.pieceLength-1) != 0 {
+		errExit(fmt.Errorf("piece length %d KiB: not a power of two up to %d KiB",
+			args.pieceLength, (math.MaxUint32/1024+1)/2))
+	}
+
+	opts := tp.CreateOptions{
+		Name:        args.name,
+		PieceLength: uint32(args.pieceLength * 1024),
+		WebSeeds:    args.webSeeds,
+		Comment:     args.comment,
+		CreatedBy:   "torrentmake",
+		Private:     args.private,
+		Source:      args.source,
+		Workers:     args.workers,
+	}
+
+	// every -t is a tier, trackers of a tier are separated by commas
+	for _, tier := range args.trackers {
+		opts.Trackers = append(opts.Trackers, strings.Split(tier, ","))
+	}
+
+	if !args.noDate {
+		opts.CreationDate = time.Now()
+	}
+
+	b, err := tp.Create(path, opts)
+	errExit(err)
+
+	m, err := tp.ParseMetaInfo(bytes.NewReader(b))
+	errExit(err)
+
+	output := args.output
+	if output == "" {
+		output = filepath.Base(filepath.Clean(path)) + ".torrent"
+	}
+
+	err = os.WriteFile(output, b, 0644)
+	errExit(err)
+
+	fmt.Print("Name\t\t", m.Info.Name, "\n",
+		"Hash\t\t", m.Info.HashStr, "\n",
+		"Files\t\t", m.Info.FilesNo, "\n",
+		"NumPieces\t", m.Info.NumPieces, "\n",
+		"PieceSize\t", m.Info.PieceLength, "\n",
+		"Output\t\t", output, "\n",
+	)
+}
+
+func errExit(err error) {
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func printUsage() {
+
+	fmt.Printf(`
+usage: %s [options] <file or directory>
+
+options:
+	-o	output file, defaults to <name>.torrent
+	-n	torrent name, defaults to the base name of the path
+	-p	piece length in KiB, picked from the total size by default
+	-t	tracker tier, trackers separated by commas, repeatable
+	-w	web seed URL, repeatable
+	-c	comment
+	-s	source
+	-P	toggle private flag
+	-D	toggle omitting the creation date
+	-j	number of hashing workers, defaults to the CPU count
+
+`, os.Args[0])
+}
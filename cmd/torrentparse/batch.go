@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	tp "github.com/torrentdb/torrent_utils/lib/torrentparse"
+)
+
+// results of parsing held at most per worker
+const jobsPerWorker = 4
+
+// torrent file to parse, n is its position in the input
+type job struct {
+	n    int
+	path string
+	err  error // set when the path couldn't be listed
+}
+
+type result struct {
+	n    int
+	path string
+	m    *tp.MetaInfo
+	res  *tp.VerifyResult
+	err  error
+}
+
+type summary struct {
+	torrents   int
+	failed     int
+	incomplete int
+	reasons    map[string]int
+}
+
+// sends the torrent files of the paths in order, directories are walked
+// recursively for *.torrent files and "-" is stdin, a token is taken for
+// each file
+func listPaths(paths []string, jobs chan<- job, tokens chan<- struct{}) {
+
+	n := 0
+	send := func(path string, err error) {
+		tokens <- struct{}{}
+		jobs <- job{n: n, path: path, err: err}
+		n++
+	}
+
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if path == "-" || err != nil || !stat.IsDir() {
+			send(path, nil)
+			continue
+		}
+
+		err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				send(p, err)
+				return nil
+			}
+			if !info.IsDir() && strings.EqualFold(filepath.Ext(p), ".torrent") {
+				send(p, nil)
+			}
+			return nil
+		})
+		if err != nil {
+			send(path, err)
+		}
+	}
+
+	close(jobs)
+}
+
+func parseFiles(jobs <-chan job, results chan<- result, opts tp.ParseOptions,
+	wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	for j := range jobs {
+		r := result{n: j.n, path: j.path, err: j.err}
+		if r.err == nil {
+			r.m, r.res, r.err = parseFile(j.path, opts)
+		}
+		results <- r
+	}
+}
+
+func parseFile(path string, opts tp.ParseOptions) (*tp.MetaInfo, *tp.VerifyResult, error) {
+
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m, err := opts.ParseMetaInfo(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if *args.verify == "" {
+		return m, nil, nil
+	}
+
+	res, err := m.Info.Verify(*args.verify)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return m, res, nil
+}
+
+// parses the paths with the workers and prints the results in the order
+// of the input
+//
+// Files are only handed to the workers while fewer than jobsPerWorker
+// results per worker are waiting to be printed, so one slow file doesn't
+// hold the results of all the others in memory.
+func parsePaths(paths []string, opts tp.ParseOptions) summary {
+
+	jobs := make(chan job)
+	results := make(chan result)
+	tokens := make(chan struct{}, jobsPerWorker**args.workers)
+	wg := new(sync.WaitGroup)
+
+	for w := 1; w <= *args.workers; w++ {
+		wg.Add(1)
+		go parseFiles(jobs, results, opts, wg)
+	}
+
+	go listPaths(paths, jobs, tokens)
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	s := summary{reasons: make(map[string]int)}
+
+	pending := make(map[int]result)
+	next := 0
+	for r := range results {
+		pending[r.n] = r
+		for {
+			r, exists := pending[next]
+			if !exists {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			s.add(r)
+			printResult(r)
+			<-tokens
+		}
+	}
+
+	return s
+}
+
+func (s *summary) add(r result) {
+
+	s.torrents++
+
+	switch {
+	case r.err != nil:
+		s.failed++
+		s.reasons[tp.ReasonOf(r.err)]++
+	case r.res != nil && !r.res.Complete():
+		s.incomplete++
+	}
+}
+
+func (s *summary) print() {
+
+	fmt.Fprintf(os.Stderr, "Torrents\t%d\nParsed\t\t%d\nFailed\t\t%d\n",
+		s.torrents, s.torrents-s.failed, s.failed)
+	if *args.verify != "" {
+		fmt.Fprintf(os.Stderr, "Incomplete\t%d\n", s.incomplete)
+	}
+
+	reasons := make([]string, 0, len(s.reasons))
+	for reason := range s.reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(os.Stderr, "Failed(%s)\t%d\n", reason, s.reasons[reason])
+	}
+}
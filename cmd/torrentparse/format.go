@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	tp "github.com/torrentdb/torrent_utils/lib/torrentparse"
+)
+
+// everything parsed from the torrent, for -format json, yaml and tsv
+type torrentOutput struct {
+	TorrentFile  string          `json:"torrent_file"`
+	Status       string          `json:"status"`
+	Name         string          `json:"name"`
+	InfoHash     string          `json:"infohash"`
+	InfoHashV2   string          `json:"infohash_v2,omitempty"`
+	MetaVersion  int             `json:"meta_version"`
+	Hybrid       bool            `json:"hybrid"`
+	Private      bool            `json:"private"`
+	Source       string          `json:"source,omitempty"`
+	Length       int64           `json:"length"`
+	PieceLength  uint32          `json:"piece_length"`
+	NumPieces    uint32          `json:"num_pieces"`
+	CreationDate string          `json:"creation_date,omitempty"`
+	CreatedBy    string          `json:"created_by,omitempty"`
+	Comment      string          `json:"comment,omitempty"`
+	Encoding     string          `json:"encoding,omitempty"`
+	Trackers     [][]string      `json:"trackers"`
+	WebSeeds     []string        `json:"web_seeds"`
+	Nodes        []string        `json:"nodes"`
+	Magnet       string          `json:"magnet"`
+	Files        []fileOutput    `json:"files"`
+	Warnings     []warningOutput `json:"warnings"`
+	Verify       *verifyOutput   `json:"verify,omitempty"`
+}
+
+// torrent that couldn't be parsed, Reason is like in torrentdb stats
+type errorOutput struct {
+	TorrentFile string `json:"torrent_file"`
+	Status      string `json:"status"`
+	Reason      string `json:"reason"`
+	Error       string `json:"error"`
+}
+
+type fileOutput struct {
+	Path        string `json:"path"`
+	Length      int64  `json:"length"`
+	Padding     bool   `json:"padding"`
+	Attr        string `json:"attr,omitempty"`
+	SymlinkPath string `json:"symlink_path,omitempty"`
+	PiecesRoot  string `json:"pieces_root,omitempty"`
+}
+
+type warningOutput struct {
+	Kind   string `json:"kind"`
+	Field  string `json:"field"`
+	Offset int64  `json:"offset"`
+	Detail string `json:"detail"`
+}
+
+type verifyOutput struct {
+	Pieces    int                `json:"pieces"`
+	BadPieces []int              `json:"bad_pieces"`
+	Complete  bool               `json:"complete"`
+	Files     []fileStatusOutput `json:"files"`
+}
+
+type fileStatusOutput struct {
+	Path       string `json:"path"`
+	Length     int64  `json:"length"`
+	Size       int64  `json:"size"` // -1 for missing files
+	Pieces     int    `json:"pieces"`
+	GoodPieces int    `json:"good_pieces"`
+	Complete   bool   `json:"complete"`
+}
+
+// writers of the -format values other than text
+var writers = map[string]func(io.Writer, interface{}) error{
+	"json": writeJSON,
+	"yaml": writeYAML,
+	"tsv":  writeTSV,
+}
+
+// the record of a parsed torrent or of the error
+func newOutput(r result) interface{} {
+
+	if r.err != nil {
+		return &errorOutput{
+			TorrentFile: r.path,
+			Status:      "error",
+			Reason:      tp.ReasonOf(r.err),
+			Error:       r.err.Error(),
+		}
+	}
+
+	m, res := r.m, r.res
+	t := m.Info
+
+	out := torrentOutput{
+		TorrentFile: r.path,
+		Status:      "ok",
+		Name:        t.Name,
+		InfoHash:    t.HashStr,
+		MetaVersion: t.MetaVersion,
+		Hybrid:      t.IsHybrid(),
+		Private:     t.Private,
+		Source:      t.Source,
+		Length:      t.Length,
+		PieceLength: t.PieceLength,
+		NumPieces:   t.NumPieces,
+		CreatedBy:   m.CreatedBy,
+		Comment:     m.Comment,
+		Encoding:    m.Encoding,
+		Trackers:    m.Trackers(),
+		WebSeeds:    m.URLList,
+		Magnet:      m.Magnet().String(),
+		Files:       make([]fileOutput, 0, len(t.Files)),
+		Warnings:    make([]warningOutput, 0, len(m.Warnings)),
+	}
+
+	if t.HasV2() {
+		out.InfoHashV2 = t.HashV2.String()
+	}
+	if !m.CreationDate.IsZero() {
+		out.CreationDate = m.CreationDate.Format(time.RFC3339)
+	}
+	if out.Trackers == nil {
+		out.Trackers = [][]string{}
+	}
+	if out.WebSeeds == nil {
+		out.WebSeeds = []string{}
+	}
+
+	out.Nodes = make([]string, 0, len(m.Nodes))
+	for _, node := range m.Nodes {
+		out.Nodes = append(out.Nodes, node.String())
+	}
+
+	for _, f := range t.Files {
+		fo := fileOutput{
+			Path:        f.Path,
+			Length:      f.Length,
+			Padding:     f.IsPadding(),
+			Attr:        f.Attr,
+			SymlinkPath: f.SymlinkPath,
+		}
+		if f.PiecesRoot != [32]byte{} {
+			fo.PiecesRoot = hex.EncodeToString(f.PiecesRoot[:])
+		}
+		out.Files = append(out.Files, fo)
+	}
+
+	for _, w := range m.Warnings {
+		out.Warnings = append(out.Warnings, warningOutput(w))
+	}
+
+	if res != nil {
+		vo := verifyOutput{
+			Pieces:    res.Pieces,
+			BadPieces: res.BadPieces,
+			Complete:  res.Complete(),
+			Files:     make([]fileStatusOutput, 0, len(res.Files)),
+		}
+		if vo.BadPieces == nil {
+			vo.BadPieces = []int{}
+		}
+		for _, f := range res.Files {
+			vo.Files = append(vo.Files, fileStatusOutput(f))
+		}
+		out.Verify = &vo
+	}
+
+	return &out
+}
+
+func writeJSON(w io.Writer, out interface{}) error {
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	return enc.Encode(out)
+}
+
+// writes the output as a YAML document with the keys of the json tags, in
+// the order of the fields, strings are always quoted
+func writeYAML(w io.Writer, out interface{}) error {
+
+	var b strings.Builder
+	b.WriteString("---\n")
+	yamlBlock(&b, reflect.ValueOf(out).Elem(), 0, true)
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// writes a struct or a non-empty slice, the first line is indented only
+// when pad is set, so list items can start after "- "
+func yamlBlock(b *strings.Builder, rv reflect.Value, indent int, pad bool) {
+
+	prefix := strings.Repeat(" ", indent)
+
+	if rv.Kind() == reflect.Slice {
+		for j := 0; j < rv.Len(); j++ {
+			if j > 0 || pad {
+				b.WriteString(prefix)
+			}
+			item := rv.Index(j)
+			if isComposite(item) {
+				b.WriteString("- ")
+				yamlBlock(b, item, indent+2, false)
+				continue
+			}
+			b.WriteString("-")
+			yamlValue(b, item, indent+2)
+		}
+		return
+	}
+
+	first := true
+	for j := 0; j < rv.NumField(); j++ {
+		key, omitEmpty := jsonKey(rv.Type().Field(j))
+		value := rv.Field(j)
+		if omitEmpty && value.IsZero() {
+			continue
+		}
+
+		if !first || pad {
+			b.WriteString(prefix)
+		}
+		first = false
+
+		b.WriteString(key)
+		b.WriteString(":")
+		yamlValue(b, value, indent+2)
+	}
+}
+
+// writes the value after a key or a dash
+func yamlValue(b *strings.Builder, rv reflect.Value, indent int) {
+
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+
+	switch {
+	case isComposite(rv):
+		b.WriteString("\n")
+		yamlBlock(b, rv, indent, true)
+		return
+
+	case rv.Kind() == reflect.Slice:
+		b.WriteString(" []")
+
+	case rv.Kind() == reflect.String:
+		// Go escapes of valid UTF-8 are also YAML escapes, invalid bytes
+		// are replaced like in JSON
+		b.WriteString(" ")
+		b.WriteString(strconv.Quote(strings.ToValidUTF8(rv.String(), "\uFFFD")))
+
+	default:
+		fmt.Fprint(b, " ", rv.Interface())
+	}
+
+	b.WriteString("\n")
+}
+
+// structs and non-empty slices are written as blocks
+func isComposite(rv reflect.Value) bool {
+
+	return rv.Kind() == reflect.Struct || (rv.Kind() == reflect.Slice && rv.Len() > 0)
+}
+
+func jsonKey(sf reflect.StructField) (string, bool) {
+
+	parts := strings.Split(sf.Tag.Get("json"), ",")
+
+	return parts[0], len(parts) > 1 && parts[1] == "omitempty"
+}
+
+// writes a row per value, lists have a row per item with the name in the
+// first column, e.g. "file<TAB>length<TAB>path", records start with the
+// torrent_file row
+func writeTSV(w io.Writer, v interface{}) error {
+
+	var b strings.Builder
+
+	row := func(values ...interface{}) {
+		for j, v := range values {
+			if j > 0 {
+				b.WriteString("\t")
+			}
+			b.WriteString(cleanField(fmt.Sprint(v)))
+		}
+		b.WriteString("\n")
+	}
+
+	if e, ok := v.(*errorOutput); ok {
+		row("torrent_file", e.TorrentFile)
+		row("status", e.Status)
+		row("reason", e.Reason)
+		row("error", e.Error)
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	out := v.(*torrentOutput)
+
+	row("torrent_file", out.TorrentFile)
+	row("status", out.Status)
+	row("name", out.Name)
+	row("infohash", out.InfoHash)
+	if out.InfoHashV2 != "" {
+		row("infohash_v2", out.InfoHashV2)
+	}
+	row("meta_version", out.MetaVersion)
+	row("hybrid", out.Hybrid)
+	row("private", out.Private)
+	if out.Source != "" {
+		row("source", out.Source)
+	}
+	row("length", out.Length)
+	row("piece_length", out.PieceLength)
+	row("num_pieces", out.NumPieces)
+	if out.CreationDate != "" {
+		row("creation_date", out.CreationDate)
+	}
+	if out.CreatedBy != "" {
+		row("created_by", out.CreatedBy)
+	}
+	if out.Comment != "" {
+		row("comment", out.Comment)
+	}
+	if out.Encoding != "" {
+		row("encoding", out.Encoding)
+	}
+	row("magnet", out.Magnet)
+
+	for tier, trackers := range out.Trackers {
+		for _, tracker := range trackers {
+			row("tracker", tier, tracker)
+		}
+	}
+	for _, url := range out.WebSeeds {
+		row("web_seed", url)
+	}
+	for _, node := range out.Nodes {
+		row("node", node)
+	}
+	for _, f := range out.Files {
+		row("file", f.Length, f.Path, f.Attr)
+	}
+	for _, ws := range out.Warnings {
+		row("warning", ws.Kind, ws.Field, ws.Offset, ws.Detail)
+	}
+
+	if v := out.Verify; v != nil {
+		row("verified_pieces", v.Pieces-len(v.BadPieces), v.Pieces)
+		for _, n := range v.BadPieces {
+			row("bad_piece", n)
+		}
+		for _, f := range v.Files {
+			row("verified_file", f.Complete, f.Size, f.Length, f.Path)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// replaces tabs, new lines and other control chars with spaces
+func cleanField(s string) string {
+
+	return strings.Map(func(c rune) rune {
+		if unicode.IsControl(c) {
+			return ' '
+		}
+		return c
+	}, s)
+}
@@ -1,68 +1,208 @@
 package main
 
 import (
-	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"runtime"
+	"strings"
 
 	tp "github.com/torrentdb/torrent_utils/lib/torrentparse"
 )
 
 type args_s struct {
-	tfile   string
 	verbose *bool
+	verify  *string
+	magnet  *bool
+	strict  *bool
+	paths   *bool
+	format  *string
+	workers *int
 }
 
 var args args_s
 
+// writer of the -format, nil for text
+var write func(io.Writer, interface{}) error
+
 func init() {
 
 	args.verbose = flag.Bool("v", false, "Print more info on torrent files")
+	args.verify = flag.String("verify", "", "Verify downloaded data in the `dir`")
+	args.magnet = flag.Bool("m", false, "Print the magnet link")
+	args.strict = flag.Bool("strict", false, "Reject torrents with warnings")
+	args.paths = flag.Bool("sanitize", false, "Sanitize file paths for Unix and Windows")
+	args.format = flag.String("format", "text", "Output `format`: text, json, yaml or tsv")
+	args.workers = flag.Int("j", runtime.NumCPU(), "Number of parsing `workers`")
 }
 
 func main() {
 
 	flag.Usage = printUsage
 	flag.Parse()
-	args.tfile = flag.Arg(0)
 
-	f, err := os.Open(args.tfile)
-	errExit(err)
+	var exists bool
+	write, exists = writers[*args.format]
+	if (!exists && *args.format != "text") || flag.NArg() == 0 || *args.workers < 1 {
+		printUsage()
+		os.Exit(2)
+	}
 
-	t, err := tp.ParseTorrent(f)
-	errExit(err)
+	opts := tp.ParseOptions{Mode: tp.Lenient}
+	if *args.strict {
+		opts.Mode = tp.Strict
+	}
+	if *args.paths {
+		opts.Paths = &tp.PathPolicy{}
+	}
 
-	printInfo(t)
+	stdin := 0
+	for _, path := range flag.Args() {
+		if path == "-" {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		errExit(fmt.Errorf("stdin \"-\" can only be read once"))
+	}
+
+	s := parsePaths(flag.Args(), opts)
+	if s.torrents > 1 {
+		s.print()
+	}
+
+	if s.failed > 0 || s.incomplete > 0 {
+		os.Exit(1)
+	}
 }
 
 func printUsage() {
 
-	fmt.Printf("Usage: %s [options] <file.torrent>\n", os.Args[0])
+	fmt.Printf("Usage: %s [options] <file.torrent | dir | ->...\n", os.Args[0])
 	flag.PrintDefaults()
 }
 
-func printInfo(t *tp.Info) {
+func printResult(r result) {
+
+	if write != nil {
+		errExit(write(os.Stdout, newOutput(r)))
+		return
+	}
+
+	fmt.Print("Torrent\t\t", r.path, "\n")
+
+	if r.err != nil {
+		fmt.Print("Error\t\t", r.err, "\n\n")
+		return
+	}
+
+	printInfo(r.m)
+	if r.res != nil {
+		printVerify(r.res)
+	}
+}
+
+func printInfo(m *tp.MetaInfo) {
 
-	fmt.Print("\nName\t\t", t.Name, "\n",
-		"Hash\t\t", hex.EncodeToString(t.Hash[:]), "\n",
-		"Files\t\t", t.FilesNo, "\n",
+	t := m.Info
+
+	fmt.Print("Name\t\t", t.Name, "\n",
+		"Hash\t\t", t.HashStr, "\n",
+	)
+
+	if t.HasV2() {
+		fmt.Print("HashV2\t\t", t.HashV2, "\n")
+	}
+
+	fmt.Print("Files\t\t", t.FilesNo, "\n",
 		"Size(MB)\t", t.Length/1024/1024, "\n",
 	)
 
+	if t.Private {
+		fmt.Print("Private\t\tyes\n")
+	}
+
+	if *args.magnet {
+		fmt.Print("Magnet\t\t", m.Magnet(), "\n")
+	}
+
 	if *args.verbose {
 
+		printMeta(m)
+
 		fmt.Print("NumPieces\t", t.NumPieces, "\n",
-			"PieceSize(MB)\t", t.PieceLength, "\n\n",
+			"PieceSize\t", t.PieceLength, "\n\n",
 		)
 
 		for i, file := range t.Files {
 			fmt.Print("File", i, "\t\t", file.Path, "\n")
 			fmt.Print("Size", i, "(MB)\t",
 				file.Length/1024/1024, "\n")
+			if file.Attr != "" {
+				fmt.Print("Attr", i, "\t\t", file.Attr, "\n")
+			}
+			if file.SymlinkPath != "" {
+				fmt.Print("Symlink", i, "\t", file.SymlinkPath, "\n")
+			}
+		}
+	}
+	fmt.Println()
+}
+
+func printMeta(m *tp.MetaInfo) {
+
+	if m.Info.Source != "" {
+		fmt.Print("Source\t\t", m.Info.Source, "\n")
+	}
+	if !m.CreationDate.IsZero() {
+		fmt.Print("Created\t\t", m.CreationDate.Format("2006-01-02 15:04"), "\n")
+	}
+	if m.CreatedBy != "" {
+		fmt.Print("CreatedBy\t", m.CreatedBy, "\n")
+	}
+	if m.Comment != "" {
+		fmt.Print("Comment\t\t", m.Comment, "\n")
+	}
+
+	for i, tier := range m.Trackers() {
+		for _, tracker := range tier {
+			fmt.Print("Tracker", i, "\t", tracker, "\n")
 		}
 	}
+	for _, url := range m.URLList {
+		fmt.Print("WebSeed\t\t", url, "\n")
+	}
+	for _, node := range m.Nodes {
+		fmt.Print("Node\t\t", node, "\n")
+	}
+	for _, w := range m.Warnings {
+		fmt.Print("Warning\t\t", w, "\n")
+	}
+}
+
+func printVerify(res *tp.VerifyResult) {
+
+	fmt.Print("Verified\t", res.Pieces-len(res.BadPieces), "/", res.Pieces,
+		" pieces\n\n")
+
+	for i, file := range res.Files {
+		status := "ok"
+		switch {
+		case file.Size < 0:
+			status = "missing"
+		case file.Size != file.Length:
+			status = fmt.Sprint("size ", file.Size, " of ", file.Length)
+		case !file.Complete:
+			status = fmt.Sprint(file.Pieces-file.GoodPieces, " bad pieces")
+		}
+		fmt.Print("File", i, "\t\t", status, "\t", file.Path, "\n")
+	}
+
+	if len(res.BadPieces) > 0 {
+		fmt.Print("\nBadPieces\t", strings.Trim(fmt.Sprint(res.BadPieces), "[]"), "\n")
+	}
 	fmt.Println()
 }
 
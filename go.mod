@@ -1,12 +1,10 @@
 module main
 
-go 1.14
+go 1.18
 
 require (
-	github.com/anacrolix/torrent v1.15.2 // indirect
-	github.com/jackpal/bencode-go v1.0.0 // indirect
 	github.com/torrentdb/torrent_utils v0.0.0
-	github.com/zeebo/bencode v1.0.0 // indirect
+	modernc.org/sqlite v1.25.0
 )
 
 replace github.com/torrentdb/torrent_utils => ../torrent_utils
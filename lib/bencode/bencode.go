@@ -0,0 +1,63 @@
+// Package bencode implements the encoding of BitTorrent metainfo files and
+// tracker responses.
+//
+// Parse decodes data into a tree of values that know their byte spans, so
+// callers can point at the offending field or hash the exact bytes of a
+// dict. Marshal writes the canonical form, Validate reports values that are
+// not in it and Reader decodes large files as a stream.
+package bencode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// already encoded value, written as it is by Marshal
+type RawMessage []byte
+
+// type of a bencoded value
+type Kind int
+
+const (
+	Invalid Kind = iota
+	Int
+	String
+	List
+	Dict
+)
+
+var kindNames = []string{"invalid", "integer", "string", "list", "dict"}
+
+func (k Kind) String() string {
+
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprint("Kind(", int(k), ")")
+	}
+
+	return kindNames[k]
+}
+
+// returned by Reader when more data than its Limit would be read
+var ErrLimit = errors.New("limit exceeded")
+
+// error with the offset of the value it's about, from the start of the data
+type Error struct {
+	Offset int64
+	Msg    string
+	Err    error // underlying error, e.g. ErrLimit or a read error
+}
+
+func (e *Error) Error() string {
+
+	return fmt.Sprintf("bencode: %s at offset %d", e.Msg, e.Offset)
+}
+
+func (e *Error) Unwrap() error {
+
+	return e.Err
+}
+
+func errorf(offset int64, format string, a ...interface{}) *Error {
+
+	return &Error{Offset: offset, Msg: fmt.Sprintf(format, a...)}
+}
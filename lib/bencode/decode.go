@@ -0,0 +1,251 @@
+package bencode
+
+import (
+	"reflect"
+	"strings"
+)
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
+
+// Unmarshal decodes data holding exactly one value into out
+func Unmarshal(data []byte, out interface{}) error {
+
+	v, err := Parse(data)
+	if err != nil {
+		return err
+	}
+
+	return v.Decode(out)
+}
+
+// Decode stores the value in out, which must be a non-nil pointer
+//
+// Integers go into integer types and bool, strings into string and []byte,
+// lists into slices, dicts into maps with string keys and structs, and any
+// value into RawMessage. Struct fields are matched by the bencode tag or the
+// field name, falling back to case-insensitive matching, unknown keys are
+// skipped. Into an empty interface integers are decoded as int64, strings
+// as string, lists as []interface{} and dicts as map[string]interface{}.
+func (v *Value) Decode(out interface{}) error {
+
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errorf(v.Start, "decoding into %T", out)
+	}
+
+	return v.decode(rv.Elem())
+}
+
+func (v *Value) decode(rv reflect.Value) error {
+
+	if rv.Type() == rawMessageType {
+		rv.SetBytes(append([]byte(nil), v.Raw...))
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return v.decode(rv.Elem())
+
+	case reflect.Interface:
+		if rv.NumMethod() != 0 {
+			return v.typeError(rv)
+		}
+		rv.Set(reflect.ValueOf(v.Interface()))
+		return nil
+	}
+
+	switch v.Kind {
+	case Int:
+		return v.decodeInt(rv)
+	case String:
+		return v.decodeString(rv)
+	case List:
+		return v.decodeList(rv)
+	case Dict:
+		return v.decodeDict(rv)
+	}
+
+	return v.typeError(rv)
+}
+
+// Interface returns the value as int64, string, []interface{} or
+// map[string]interface{}
+func (v *Value) Interface() interface{} {
+
+	switch v.Kind {
+	case Int:
+		return v.Int
+	case String:
+		return string(v.Str)
+	case List:
+		list := make([]interface{}, len(v.List))
+		for j, item := range v.List {
+			list[j] = item.Interface()
+		}
+		return list
+	case Dict:
+		dict := make(map[string]interface{}, len(v.Dict))
+		for _, pair := range v.Dict {
+			dict[pair.Key] = pair.Value.Interface()
+		}
+		return dict
+	}
+
+	return nil
+}
+
+func (v *Value) typeError(rv reflect.Value) error {
+
+	return errorf(v.Start, "cannot decode %s into %s", v.Kind, rv.Type())
+}
+
+func (v *Value) decodeInt(rv reflect.Value) error {
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rv.OverflowInt(v.Int) {
+			return errorf(v.Start, "integer %d overflows %s", v.Int, rv.Type())
+		}
+		rv.SetInt(v.Int)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Int < 0 || rv.OverflowUint(uint64(v.Int)) {
+			return errorf(v.Start, "integer %d overflows %s", v.Int, rv.Type())
+		}
+		rv.SetUint(uint64(v.Int))
+
+	case reflect.Bool:
+		rv.SetBool(v.Int != 0)
+
+	default:
+		return v.typeError(rv)
+	}
+
+	return nil
+}
+
+func (v *Value) decodeString(rv reflect.Value) error {
+
+	switch {
+	case rv.Kind() == reflect.String:
+		rv.SetString(string(v.Str))
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+		rv.SetBytes(append([]byte(nil), v.Str...))
+	default:
+		return v.typeError(rv)
+	}
+
+	return nil
+}
+
+func (v *Value) decodeList(rv reflect.Value) error {
+
+	if rv.Kind() != reflect.Slice {
+		return v.typeError(rv)
+	}
+
+	list := reflect.MakeSlice(rv.Type(), len(v.List), len(v.List))
+	for j, item := range v.List {
+		if err := item.decode(list.Index(j)); err != nil {
+			return err
+		}
+	}
+	rv.Set(list)
+
+	return nil
+}
+
+func (v *Value) decodeDict(rv reflect.Value) error {
+
+	switch {
+	case rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String:
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMapWithSize(rv.Type(), len(v.Dict)))
+		}
+		for _, pair := range v.Dict {
+			item := reflect.New(rv.Type().Elem()).Elem()
+			if err := pair.Value.decode(item); err != nil {
+				return err
+			}
+			rv.SetMapIndex(reflect.ValueOf(pair.Key).Convert(rv.Type().Key()), item)
+		}
+		return nil
+
+	case rv.Kind() == reflect.Struct:
+		fields := structFields(rv.Type())
+		for _, pair := range v.Dict {
+			index, exists := fields.match(pair.Key)
+			if !exists {
+				continue
+			}
+			if err := pair.Value.decode(rv.FieldByIndex(index)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return v.typeError(rv)
+}
+
+// struct field with its key
+type field struct {
+	key       string
+	index     []int
+	omitEmpty bool
+}
+
+type fieldList []field
+
+func (fields fieldList) match(key string) ([]int, bool) {
+
+	for _, f := range fields {
+		if f.key == key {
+			return f.index, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.key, key) {
+			return f.index, true
+		}
+	}
+
+	return nil, false
+}
+
+// exported fields of the struct, fields tagged "-" are skipped
+func structFields(t reflect.Type) fieldList {
+
+	var fields fieldList
+
+	for j := 0; j < t.NumField(); j++ {
+		sf := t.Field(j)
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		tag := sf.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		f := field{key: parts[0], index: sf.Index}
+		if f.key == "" {
+			f.key = sf.Name
+		}
+		for _, option := range parts[1:] {
+			if option == "omitempty" {
+				f.omitEmpty = true
+			}
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields
+}
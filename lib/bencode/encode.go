@@ -0,0 +1,158 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Marshal returns the canonical encoding of v
+//
+// Dict keys are sorted, struct fields tagged omitempty are left out when
+// empty, bools are written as 0 and 1 and RawMessage values as they are.
+// Nil pointers and interfaces can only be left out of dicts.
+func Marshal(v interface{}) ([]byte, error) {
+
+	var b bytes.Buffer
+	if err := encode(&b, reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func encode(b *bytes.Buffer, rv reflect.Value) error {
+
+	if !rv.IsValid() {
+		return fmt.Errorf("bencode: cannot encode nil")
+	}
+
+	if rv.Type() == rawMessageType {
+		if rv.Len() == 0 {
+			return fmt.Errorf("bencode: cannot encode empty RawMessage")
+		}
+		b.Write(rv.Bytes())
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return fmt.Errorf("bencode: cannot encode nil %s", rv.Type())
+		}
+		return encode(b, rv.Elem())
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		b.WriteByte('i')
+		b.WriteString(strconv.FormatInt(rv.Int(), 10))
+		b.WriteByte('e')
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		b.WriteByte('i')
+		b.WriteString(strconv.FormatUint(rv.Uint(), 10))
+		b.WriteByte('e')
+
+	case reflect.Bool:
+		if rv.Bool() {
+			b.WriteString("i1e")
+		} else {
+			b.WriteString("i0e")
+		}
+
+	case reflect.String:
+		encodeString(b, rv.String())
+
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			s := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(s), rv)
+			encodeString(b, string(s))
+			return nil
+		}
+		b.WriteByte('l')
+		for j := 0; j < rv.Len(); j++ {
+			if err := encode(b, rv.Index(j)); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('e')
+
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("bencode: cannot encode %s", rv.Type())
+		}
+		keys := make([]string, 0, rv.Len())
+		values := make(map[string]reflect.Value, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, k.String())
+			values[k.String()] = rv.MapIndex(k)
+		}
+		sort.Strings(keys)
+		b.WriteByte('d')
+		for _, key := range keys {
+			encodeString(b, key)
+			if err := encode(b, values[key]); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('e')
+
+	case reflect.Struct:
+		return encodeStruct(b, rv)
+
+	default:
+		return fmt.Errorf("bencode: cannot encode %s", rv.Type())
+	}
+
+	return nil
+}
+
+func encodeString(b *bytes.Buffer, s string) {
+
+	b.WriteString(strconv.Itoa(len(s)))
+	b.WriteByte(':')
+	b.WriteString(s)
+}
+
+func encodeStruct(b *bytes.Buffer, rv reflect.Value) error {
+
+	fields := structFields(rv.Type())
+	sort.SliceStable(fields, func(x, y int) bool {
+		return fields[x].key < fields[y].key
+	})
+
+	b.WriteByte('d')
+	for _, f := range fields {
+		value := rv.FieldByIndex(f.index)
+		if f.omitEmpty && isEmpty(value) {
+			continue
+		}
+		encodeString(b, f.key)
+		if err := encode(b, value); err != nil {
+			return err
+		}
+	}
+	b.WriteByte('e')
+
+	return nil
+}
+
+func isEmpty(rv reflect.Value) bool {
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint() == 0
+	case reflect.Bool:
+		return !rv.Bool()
+	}
+
+	return false
+}
@@ -0,0 +1,53 @@
+package bencode
+
+import (
+	"testing"
+)
+
+// dict keys are written sorted as raw bytes, whatever their order in the
+// map, the struct or the decoded data
+func TestMarshalKeyOrder(t *testing.T) {
+
+	type inner struct {
+		Z int    `bencode:"z"`
+		A string `bencode:"a"`
+	}
+	type outer struct {
+		B     int            `bencode:"b"`
+		C     map[string]int `bencode:"c"`
+		A     inner          `bencode:"a"`
+		Skip  int            `bencode:"-"`
+		Empty string         `bencode:"e,omitempty"`
+		Name  string
+	}
+
+	decoded, err := Parse([]byte("d1:bi1e1:ai2e1:Bi3ee"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{map[string]int{"b": 1, "a": 2, "B": 3, "ab": 4, "é": 5, "": 6},
+			"d0:i6e1:Bi3e1:ai2e2:abi4e1:bi1e2:éi5ee"},
+		{outer{B: 1, C: map[string]int{"y": 2, "x": 3}, A: inner{Z: 4, A: "s"}, Skip: 5, Name: "n"},
+			"d4:Name1:n1:ad1:a1:s1:zi4ee1:bi1e1:cd1:xi3e1:yi2eee"},
+		{decoded.Interface(), "d1:Bi3e1:ai2e1:bi1ee"},
+	}
+
+	for _, test := range tests {
+		b, err := Marshal(test.v)
+		if err != nil {
+			t.Errorf("%v: %v", test.v, err)
+			continue
+		}
+		if string(b) != test.want {
+			t.Errorf("%v: encoded as %q, want %q", test.v, b, test.want)
+		}
+		if issues, err := Validate(b); err != nil || len(issues) > 0 {
+			t.Errorf("%q: %v %v", b, issues, err)
+		}
+	}
+}
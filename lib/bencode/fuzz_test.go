@@ -0,0 +1,137 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/torrentdb/torrent_utils/lib/internal/seedcorpus"
+)
+
+var seedValues = []string{
+	"i0e", "i-1e", "i-0e", "i03e", "0:", "4:spam", "04:spam", "le", "de",
+	"l4:spami42ee", "d3:bar4:spam3:fooi42ee", "d3:foo0:3:bar0:e",
+	"d1:a0:1:a0:e", "d1:ad1:bl1:ceee", "i9223372036854775807e",
+	"i9223372036854775808e", "d", "l", "1:", "x", "i1ei2e",
+}
+
+func addSeeds(f *testing.F) {
+
+	for _, s := range seedValues {
+		f.Add([]byte(s))
+	}
+
+	for _, b := range seedcorpus.Torrents(f) {
+		f.Add(b)
+	}
+}
+
+// decoding never panics, errors point inside the data and values span it
+func FuzzParse(f *testing.F) {
+
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+
+		v, err := Parse(data)
+		if err != nil {
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("error of type %T: %v", err, err)
+			}
+			if e.Offset < 0 || e.Offset > int64(len(data)) {
+				t.Fatalf("offset %d outside of %d bytes: %v", e.Offset, len(data), err)
+			}
+			return
+		}
+
+		checkSpans(t, v, data)
+	})
+}
+
+func checkSpans(t *testing.T, v *Value, data []byte) {
+
+	if v.Start < 0 || v.End > int64(len(data)) || v.Start >= v.End {
+		t.Fatalf("span %d-%d outside of %d bytes", v.Start, v.End, len(data))
+	}
+	if !bytes.Equal(v.Raw, data[v.Start:v.End]) {
+		t.Fatalf("Raw of %s at %d doesn't match its span", v.Kind, v.Start)
+	}
+
+	for _, item := range v.List {
+		checkSpans(t, item, data)
+	}
+	for _, pair := range v.Dict {
+		if pair.KeyStart <= v.Start || pair.KeyStart >= pair.Value.Start {
+			t.Fatalf("key %q at %d outside of its dict", pair.Key, pair.KeyStart)
+		}
+		checkSpans(t, pair.Value, data)
+	}
+}
+
+// canonical data is encoded back byte for byte, the encoding of anything
+// decoded is canonical and decodes to the same value
+func FuzzCanonical(f *testing.F) {
+
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+
+		v, err := Parse(data)
+		if err != nil {
+			return
+		}
+
+		b, err := Marshal(v.Interface())
+		if err != nil {
+			t.Fatalf("encoding decoded value: %v", err)
+		}
+
+		if len(v.Validate()) == 0 && !bytes.Equal(b, data) {
+			t.Fatalf("canonical %q encoded as %q", data, b)
+		}
+
+		w, err := Parse(b)
+		if err != nil {
+			t.Fatalf("decoding %q: %v", b, err)
+		}
+		if issues := w.Validate(); len(issues) > 0 {
+			t.Fatalf("encoding %q not canonical: %v", b, issues[0])
+		}
+		if !reflect.DeepEqual(w.Interface(), v.Interface()) {
+			t.Fatalf("%q decoded differently after encoding as %q", data, b)
+		}
+	})
+}
+
+// the streaming reader agrees with Parse on values and issues
+func FuzzReader(f *testing.F) {
+
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+
+		v, _, err := ParsePrefix(data)
+
+		r := NewReader(bytes.NewReader(data))
+		raw, readErr := r.ReadValue()
+
+		if (err == nil) != (readErr == nil) {
+			t.Fatalf("Parse error %v, Reader error %v", err, readErr)
+		}
+		if err != nil {
+			return
+		}
+
+		if !bytes.Equal(raw, v.Raw) {
+			t.Fatalf("Reader read %q, Parse %q", raw, v.Raw)
+		}
+		if r.Offset() != v.End {
+			t.Fatalf("Reader stopped at %d, Parse at %d", r.Offset(), v.End)
+		}
+		if issues := v.Validate(); !reflect.DeepEqual(r.Issues, issues) {
+			t.Fatalf("Reader issues %v, Validate %v", r.Issues, issues)
+		}
+	})
+}
@@ -0,0 +1,363 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Reader decodes a bencoded stream without loading it whole, offsets of
+// errors and issues are from the start of the stream
+//
+// Values are read whole with ReadValue, strings are copied to a writer with
+// ReadString and dicts and lists are walked with ReadDict and ReadList, so
+// large values like the piece hashes can be skipped or hashed on the fly.
+type Reader struct {
+	Limit  int64     // bytes allowed to be read, 0 is no limit
+	Tee    io.Writer // while set the bytes read are copied to it
+	Issues []Issue   // values not in the canonical form, in the order read
+
+	r      *bufio.Reader
+	offset int64
+	path   string // of the value being read
+}
+
+func NewReader(r io.Reader) *Reader {
+
+	return &Reader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Offset returns the number of bytes read
+func (r *Reader) Offset() int64 {
+
+	return r.offset
+}
+
+// Peek returns the next byte without reading it
+func (r *Reader) Peek() (byte, error) {
+
+	b, err := r.r.Peek(1)
+	if err != nil {
+		return 0, r.readError(err)
+	}
+
+	return b[0], nil
+}
+
+func (r *Reader) readByte() (byte, error) {
+
+	if err := r.reserve(1); err != nil {
+		return 0, err
+	}
+
+	c, err := r.r.ReadByte()
+	if err != nil {
+		return 0, r.readError(err)
+	}
+	r.offset++
+
+	if r.Tee != nil {
+		_, _ = r.Tee.Write([]byte{c})
+	}
+
+	return c, nil
+}
+
+// copies the next n bytes to w
+func (r *Reader) readN(n int64, w io.Writer) error {
+
+	if err := r.reserve(n); err != nil {
+		return err
+	}
+
+	if r.Tee != nil {
+		w = io.MultiWriter(w, r.Tee)
+	}
+
+	copied, err := io.CopyN(w, r.r, n)
+	r.offset += copied
+	if err != nil {
+		return r.readError(err)
+	}
+
+	return nil
+}
+
+func (r *Reader) reserve(n int64) error {
+
+	if r.Limit > 0 && r.offset+n > r.Limit {
+		return &Error{Offset: r.offset, Msg: fmt.Sprintf("more than %d bytes", r.Limit), Err: ErrLimit}
+	}
+
+	return nil
+}
+
+func (r *Reader) readError(err error) error {
+
+	if err == io.EOF {
+		return &Error{Offset: r.offset, Msg: "unexpected end of data", Err: io.ErrUnexpectedEOF}
+	}
+
+	return &Error{Offset: r.offset, Msg: "read failed", Err: err}
+}
+
+func (r *Reader) issue(kind string, path string, offset int64, detail string) {
+
+	r.Issues = append(r.Issues, Issue{Kind: kind, Path: path, Offset: offset, Detail: detail})
+}
+
+// reads the length of a string up to the colon, raw receives the digits
+func (r *Reader) readLength(raw *bytes.Buffer) (int64, error) {
+
+	offset := r.offset
+
+	var digits []byte
+	for {
+		c, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if raw != nil {
+			raw.WriteByte(c)
+		}
+		if c == ':' {
+			break
+		}
+		if c < '0' || c > '9' || len(digits) > 18 {
+			return 0, errorf(offset, "invalid string length")
+		}
+		digits = append(digits, c)
+	}
+
+	n, err := strconv.ParseInt(string(digits), 10, 64)
+	if err != nil {
+		return 0, errorf(offset, "invalid string length")
+	}
+
+	return n, nil
+}
+
+// reads a whole value into raw
+func (r *Reader) readValue(raw *bytes.Buffer, nesting int) error {
+
+	start := r.offset
+
+	c, err := r.Peek()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case c == 'i':
+		if _, err := r.readByte(); err != nil {
+			return err
+		}
+		raw.WriteByte(c)
+		for {
+			c, err := r.readByte()
+			if err != nil {
+				return err
+			}
+			raw.WriteByte(c)
+			if c == 'e' {
+				return nil
+			}
+			if c != '-' && (c < '0' || c > '9') {
+				return errorf(start, "invalid integer")
+			}
+		}
+
+	case c == 'l' || c == 'd':
+		if nesting >= maxNesting {
+			return errorf(start, "nesting deeper than %d", maxNesting)
+		}
+		if _, err := r.readByte(); err != nil {
+			return err
+		}
+		raw.WriteByte(c)
+		for j := 0; ; j++ {
+			next, err := r.Peek()
+			if err != nil {
+				return err
+			}
+			// even elements of dicts are the keys
+			if next == 'e' && (c == 'l' || j%2 == 0) {
+				if _, err := r.readByte(); err != nil {
+					return err
+				}
+				raw.WriteByte(next)
+				return nil
+			}
+			if c == 'd' && j%2 == 0 && (next < '0' || next > '9') {
+				return errorf(r.offset, "dict key is not a string")
+			}
+			if err := r.readValue(raw, nesting+1); err != nil {
+				return err
+			}
+		}
+
+	case c >= '0' && c <= '9':
+		n, err := r.readLength(raw)
+		if err != nil {
+			return err
+		}
+		return r.readN(n, raw)
+	}
+
+	return errorf(start, "invalid value %q", c)
+}
+
+// ReadValue reads the next value whole and reports its issues
+func (r *Reader) ReadValue() (RawMessage, error) {
+
+	start := r.offset
+
+	var raw bytes.Buffer
+	if err := r.readValue(&raw, 0); err != nil {
+		return nil, err
+	}
+
+	v, _, err := ParsePrefix(raw.Bytes())
+	if e, ok := err.(*Error); ok {
+		e.Offset += start
+		return nil, e
+	}
+	v.validate(&r.Issues, r.path, start)
+
+	return raw.Bytes(), nil
+}
+
+// ReadString copies the next string to w and returns its length
+func (r *Reader) ReadString(w io.Writer) (int64, error) {
+
+	offset := r.offset
+
+	c, err := r.Peek()
+	if err != nil {
+		return 0, err
+	}
+	if c < '0' || c > '9' {
+		return 0, errorf(offset, "expected a string")
+	}
+
+	n, err := r.readLength(nil)
+	if err != nil {
+		return 0, err
+	}
+	if c == '0' && r.offset-offset > 2 {
+		r.issue(IssueNonCanonical, r.path, offset, "string length with leading zeros")
+	}
+
+	return n, r.readN(n, w)
+}
+
+// ReadDict reads a dict calling fn for every key, fn has to read the value
+func (r *Reader) ReadDict(fn func(key string) error) error {
+
+	c, err := r.Peek()
+	if err != nil {
+		return err
+	}
+	if c != 'd' {
+		return errorf(r.offset, "expected a dict")
+	}
+	if _, err := r.readByte(); err != nil {
+		return err
+	}
+
+	path := r.path
+	defer func() {
+		r.path = path
+	}()
+
+	var prev string
+	for first := true; ; first = false {
+		c, err := r.Peek()
+		if err != nil {
+			return err
+		}
+		if c == 'e' {
+			_, err := r.readByte()
+			return err
+		}
+		if c < '0' || c > '9' {
+			return errorf(r.offset, "dict key is not a string")
+		}
+
+		keyStart := r.offset
+		n, err := r.readLength(nil)
+		if err != nil {
+			return err
+		}
+		if c == '0' && r.offset-keyStart > 2 {
+			r.issue(IssueNonCanonical, path, keyStart, "key length with leading zeros")
+		}
+
+		var b bytes.Buffer
+		if err := r.readN(n, &b); err != nil {
+			return err
+		}
+		key := b.String()
+		r.path = joinPath(path, key)
+
+		if !first {
+			switch {
+			case key == prev:
+				r.issue(IssueDuplicateKey, r.path, r.offset, "")
+			case key < prev:
+				r.issue(IssueUnsortedKeys, r.path, r.offset, fmt.Sprintf("after %q", prev))
+			}
+		}
+		prev = key
+
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+}
+
+// ReadList reads a list calling fn for every element, fn has to read it
+func (r *Reader) ReadList(fn func(index int) error) error {
+
+	c, err := r.Peek()
+	if err != nil {
+		return err
+	}
+	if c != 'l' {
+		return errorf(r.offset, "expected a list")
+	}
+	if _, err := r.readByte(); err != nil {
+		return err
+	}
+
+	path := r.path
+	defer func() {
+		r.path = path
+	}()
+
+	for j := 0; ; j++ {
+		c, err := r.Peek()
+		if err != nil {
+			return err
+		}
+		if c == 'e' {
+			_, err := r.readByte()
+			return err
+		}
+
+		r.path = joinPath(path, strconv.Itoa(j))
+		if err := fn(j); err != nil {
+			return err
+		}
+	}
+}
+
+// End reports data following the value read as an issue
+func (r *Reader) End() {
+
+	if _, err := r.r.Peek(1); err == nil {
+		r.issue(IssueNonCanonical, "", r.offset, "data after the end")
+	}
+}
@@ -0,0 +1,117 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// reads past the Limit fail with ErrLimit at the offset of the read,
+// without reading the bytes
+func TestReaderLimit(t *testing.T) {
+
+	const data = "d3:bar4:spam3:fooi42ee"
+
+	tests := []struct {
+		limit  int64
+		offset int64 // of the error, -1 for none
+		err    error
+	}{
+		{0, -1, nil},
+		{22, -1, nil},
+		{21, 21, ErrLimit},
+		{10, 8, ErrLimit}, // "spam" is read at once
+		{1, 1, ErrLimit},
+	}
+
+	for _, test := range tests {
+		r := NewReader(bytes.NewReader([]byte(data)))
+		r.Limit = test.limit
+
+		raw, err := r.ReadValue()
+		if test.err == nil {
+			if err != nil || string(raw) != data {
+				t.Errorf("limit %d: %q %v", test.limit, raw, err)
+			}
+			continue
+		}
+
+		var e *Error
+		if !errors.As(err, &e) || !errors.Is(err, test.err) || e.Offset != test.offset {
+			t.Errorf("limit %d: %v, want %v at offset %d", test.limit, err, test.err, test.offset)
+		}
+		if r.Offset() != test.offset {
+			t.Errorf("limit %d: read %d bytes, want %d", test.limit, r.Offset(), test.offset)
+		}
+	}
+
+	// large strings are refused before being read
+	r := NewReader(bytes.NewReader([]byte("d1:ai1e4:data1000:")))
+	r.Limit = 100
+	err := r.ReadDict(func(key string) error {
+		if key == "data" {
+			_, err := r.ReadString(io.Discard)
+			return err
+		}
+		_, err := r.ReadValue()
+		return err
+	})
+	if !errors.Is(err, ErrLimit) || r.Offset() != 18 {
+		t.Errorf("string past the limit: %v after %d bytes", err, r.Offset())
+	}
+
+	// the end of the data isn't a limit
+	r = NewReader(bytes.NewReader([]byte("4:spa")))
+	if _, err := r.ReadValue(); !errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrLimit) {
+		t.Errorf("truncated: %v", err)
+	}
+}
+
+// the bytes read while Tee is set are copied to it, whether the value is
+// read whole or walked
+func TestReaderTee(t *testing.T) {
+
+	const info = "d6:lengthi1e4:name1:x6:pieces4:abcde"
+	const data = "d8:announce3:url4:info" + info + "e"
+
+	for _, walk := range []bool{false, true} {
+		var tee bytes.Buffer
+		r := NewReader(bytes.NewReader([]byte(data)))
+
+		err := r.ReadDict(func(key string) error {
+			if key != "info" {
+				_, err := r.ReadValue()
+				return err
+			}
+
+			r.Tee = &tee
+			defer func() {
+				r.Tee = nil
+			}()
+
+			if !walk {
+				_, err := r.ReadValue()
+				return err
+			}
+			return r.ReadDict(func(key string) error {
+				if key == "pieces" {
+					_, err := r.ReadString(io.Discard)
+					return err
+				}
+				_, err := r.ReadValue()
+				return err
+			})
+		})
+		if err != nil {
+			t.Fatalf("walk %v: %v", walk, err)
+		}
+
+		if tee.String() != info {
+			t.Errorf("walk %v: tee %q, want %q", walk, tee.String(), info)
+		}
+		if r.Offset() != int64(len(data)) {
+			t.Errorf("walk %v: read %d bytes", walk, r.Offset())
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package bencode
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// kinds of issues, values that decode fine but are not in the canonical form
+const (
+	IssueNonCanonical = "non-canonical"
+	IssueUnsortedKeys = "unsorted-keys"
+	IssueDuplicateKey = "duplicate-key"
+)
+
+// value not in the canonical form, Path is made of the dict keys and list
+// indexes leading to it joined with "/"
+type Issue struct {
+	Kind   string
+	Path   string
+	Offset int64
+	Detail string
+}
+
+func (is Issue) String() string {
+
+	if is.Path == "" {
+		return fmt.Sprintf("%s at offset %d: %s", is.Kind, is.Offset, is.Detail)
+	}
+
+	return fmt.Sprintf("%s: %q at offset %d: %s", is.Kind, is.Path, is.Offset, is.Detail)
+}
+
+// Validate decodes the value at the start of data and reports integers and
+// lengths with leading zeros or negative zero, unsorted and duplicate dict
+// keys and data after the end
+func Validate(data []byte) ([]Issue, error) {
+
+	v, n, err := ParsePrefix(data)
+	if err != nil {
+		return nil, err
+	}
+
+	issues := v.Validate()
+	if n < len(data) {
+		issues = append(issues, Issue{Kind: IssueNonCanonical, Offset: int64(n),
+			Detail: "data after the end"})
+	}
+
+	return issues, nil
+}
+
+// Validate reports the parts of the value not in the canonical form, in
+// the order of the data
+func (v *Value) Validate() []Issue {
+
+	var issues []Issue
+	v.validate(&issues, "", 0)
+
+	return issues
+}
+
+// offsets are shifted by base, used for values read by Reader
+func (v *Value) validate(issues *[]Issue, path string, base int64) {
+
+	add := func(kind, path string, offset int64, detail string) {
+		*issues = append(*issues, Issue{Kind: kind, Path: path, Offset: base + offset, Detail: detail})
+	}
+
+	switch v.Kind {
+	case Int:
+		digits := string(v.Raw[1 : len(v.Raw)-1])
+		if strconv.FormatInt(v.Int, 10) != digits {
+			add(IssueNonCanonical, path, v.Start, fmt.Sprintf("integer %q", digits))
+		}
+
+	case String:
+		if leadingZeros(v.Raw) {
+			add(IssueNonCanonical, path, v.Start, "string length with leading zeros")
+		}
+
+	case List:
+		for j, item := range v.List {
+			item.validate(issues, joinPath(path, strconv.Itoa(j)), base)
+		}
+
+	case Dict:
+		for j, pair := range v.Dict {
+			if leadingZeros(v.Raw[pair.KeyStart-v.Start:]) {
+				add(IssueNonCanonical, path, pair.KeyStart, "key length with leading zeros")
+			}
+
+			keyPath := joinPath(path, pair.Key)
+			if j > 0 {
+				prev := v.Dict[j-1].Key
+				switch {
+				case pair.Key == prev:
+					add(IssueDuplicateKey, keyPath, pair.Value.Start, "")
+				case pair.Key < prev:
+					add(IssueUnsortedKeys, keyPath, pair.Value.Start, fmt.Sprintf("after %q", prev))
+				}
+			}
+
+			pair.Value.validate(issues, keyPath, base)
+		}
+	}
+}
+
+// the encoded string starts with a length like "05:"
+func leadingZeros(raw []byte) bool {
+
+	return len(raw) > 1 && raw[0] == '0' && raw[1] != ':'
+}
+
+func joinPath(path, key string) string {
+
+	if path == "" {
+		return key
+	}
+
+	return path + "/" + key
+}
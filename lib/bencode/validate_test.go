@@ -0,0 +1,65 @@
+package bencode
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// each value not in the canonical form is reported with its path and
+// offset, the same by Validate and by Reader
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		data   string
+		issues []Issue
+	}{
+		{"d3:bar4:spam3:fooi42ee", nil},
+		{"i0e", nil},
+		{"0:", nil},
+
+		{"i-0e", []Issue{{IssueNonCanonical, "", 0, `integer "-0"`}}},
+		{"i007e", []Issue{{IssueNonCanonical, "", 0, `integer "007"`}}},
+		{"i-07e", []Issue{{IssueNonCanonical, "", 0, `integer "-07"`}}},
+		{"04:spam", []Issue{{IssueNonCanonical, "", 0, "string length with leading zeros"}}},
+		{"00:", []Issue{{IssueNonCanonical, "", 0, "string length with leading zeros"}}},
+		{"d03:fooi1ee", []Issue{{IssueNonCanonical, "", 1, "key length with leading zeros"}}},
+		{"i1ei2e", []Issue{{IssueNonCanonical, "", 3, "data after the end"}}},
+		{"d1:ald1:bi-0eeee", []Issue{{IssueNonCanonical, "a/0/b", 9, `integer "-0"`}}},
+
+		// keys are sorted as raw bytes
+		{"d1:Bi1e1:ai2ee", nil},
+		{"d2:abi1e1:bi2ee", nil},
+		{"d3:fooi1e3:bari2ee", []Issue{{IssueUnsortedKeys, "bar", 14, `after "foo"`}}},
+		{"d1:ai1e1:Bi2ee", []Issue{{IssueUnsortedKeys, "B", 10, `after "a"`}}},
+		{"d1:bi1e2:abi2ee", []Issue{{IssueUnsortedKeys, "ab", 11, `after "b"`}}},
+		{"d3:fooi1e3:fooi2ee", []Issue{{IssueDuplicateKey, "foo", 14, ""}}},
+
+		// in the order of the data
+		{"ld1:bi1e1:ai2ee04:spame", []Issue{
+			{IssueUnsortedKeys, "0/a", 11, `after "b"`},
+			{IssueNonCanonical, "1", 15, "string length with leading zeros"},
+		}},
+	}
+
+	for _, test := range tests {
+		issues, err := Validate([]byte(test.data))
+		if err != nil {
+			t.Errorf("%q: %v", test.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(issues, test.issues) {
+			t.Errorf("%q: issues %v, want %v", test.data, issues, test.issues)
+		}
+
+		r := NewReader(bytes.NewReader([]byte(test.data)))
+		if _, err := r.ReadValue(); err != nil {
+			t.Errorf("%q: Reader: %v", test.data, err)
+			continue
+		}
+		r.End()
+		if !reflect.DeepEqual(r.Issues, test.issues) {
+			t.Errorf("%q: Reader issues %v, want %v", test.data, r.Issues, test.issues)
+		}
+	}
+}
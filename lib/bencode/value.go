@@ -0,0 +1,215 @@
+package bencode
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// nesting of lists and dicts accepted by the decoders
+const maxNesting = 512
+
+// decoded value with its position in the data, Raw is a slice of the data
+// given to Parse and shares its memory
+type Value struct {
+	Kind  Kind
+	Start int64 // offset of the first byte
+	End   int64 // offset after the last byte
+	Raw   []byte
+	Int   int64    // Int
+	Str   []byte   // String
+	List  []*Value // List
+	Dict  []Pair   // Dict, in the order of the data
+}
+
+// key and value of a dict
+type Pair struct {
+	Key      string
+	KeyStart int64 // offset of the encoded key
+	Value    *Value
+}
+
+// Parse decodes data holding exactly one value
+func Parse(data []byte) (*Value, error) {
+
+	v, n, err := ParsePrefix(data)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(data) {
+		return nil, errorf(int64(n), "data after the end")
+	}
+
+	return v, nil
+}
+
+// ParsePrefix decodes the value at the start of data and returns the
+// number of bytes it takes, the rest of data is ignored
+func ParsePrefix(data []byte) (*Value, int, error) {
+
+	p := parser{data: data}
+
+	v, err := p.value(0)
+	if err != nil {
+		return nil, p.pos, err
+	}
+
+	return v, p.pos, nil
+}
+
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func (p *parser) value(nesting int) (*Value, error) {
+
+	if p.pos >= len(p.data) {
+		return nil, errorf(int64(p.pos), "unexpected end of data")
+	}
+
+	start := p.pos
+	v := &Value{Start: int64(start)}
+
+	switch c := p.data[p.pos]; {
+	case c == 'i':
+		end := bytes.IndexByte(p.data[start:], 'e')
+		if end < 0 {
+			return nil, errorf(int64(start), "unterminated integer")
+		}
+		n, err := parseInt(p.data[start+1 : start+end])
+		if err != nil {
+			return nil, errorf(int64(start), "invalid integer %q", p.data[start+1:start+end])
+		}
+		v.Kind = Int
+		v.Int = n
+		p.pos = start + end + 1
+
+	case c >= '0' && c <= '9':
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		v.Kind = String
+		v.Str = s
+
+	case c == 'l':
+		if nesting >= maxNesting {
+			return nil, errorf(int64(start), "nesting deeper than %d", maxNesting)
+		}
+		v.Kind = List
+		p.pos++
+		for p.pos < len(p.data) && p.data[p.pos] != 'e' {
+			item, err := p.value(nesting + 1)
+			if err != nil {
+				return nil, err
+			}
+			v.List = append(v.List, item)
+		}
+		if p.pos >= len(p.data) {
+			return nil, errorf(int64(start), "unterminated list")
+		}
+		p.pos++
+
+	case c == 'd':
+		if nesting >= maxNesting {
+			return nil, errorf(int64(start), "nesting deeper than %d", maxNesting)
+		}
+		v.Kind = Dict
+		p.pos++
+		for p.pos < len(p.data) && p.data[p.pos] != 'e' {
+			keyStart := p.pos
+			if c := p.data[p.pos]; c < '0' || c > '9' {
+				return nil, errorf(int64(keyStart), "dict key is not a string")
+			}
+			key, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			item, err := p.value(nesting + 1)
+			if err != nil {
+				return nil, err
+			}
+			v.Dict = append(v.Dict, Pair{Key: string(key), KeyStart: int64(keyStart), Value: item})
+		}
+		if p.pos >= len(p.data) {
+			return nil, errorf(int64(start), "unterminated dict")
+		}
+		p.pos++
+
+	default:
+		return nil, errorf(int64(start), "invalid value %q", c)
+	}
+
+	v.End = int64(p.pos)
+	v.Raw = p.data[start:p.pos]
+
+	return v, nil
+}
+
+func (p *parser) str() ([]byte, error) {
+
+	start := p.pos
+
+	colon := bytes.IndexByte(p.data[start:], ':')
+	if colon < 0 || colon > 19 {
+		return nil, errorf(int64(start), "invalid string length")
+	}
+
+	length, err := strconv.ParseInt(string(p.data[start:start+colon]), 10, 64)
+	if err != nil || length < 0 {
+		return nil, errorf(int64(start), "invalid string length")
+	}
+
+	from := start + colon + 1
+	if length > int64(len(p.data)-from) {
+		return nil, errorf(int64(start), "string longer than the data")
+	}
+
+	p.pos = from + int(length)
+
+	return p.data[from:p.pos], nil
+}
+
+// integers are digits with an optional minus sign
+func parseInt(digits []byte) (int64, error) {
+
+	s := string(digits)
+	if s == "" || s == "-" {
+		return 0, strconv.ErrSyntax
+	}
+	for j, c := range s {
+		if (c < '0' || c > '9') && !(j == 0 && c == '-') {
+			return 0, strconv.ErrSyntax
+		}
+	}
+
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// Get returns the value of the first key of a dict, nil if it's missing
+func (v *Value) Get(key string) *Value {
+
+	if v == nil {
+		return nil
+	}
+
+	for _, pair := range v.Dict {
+		if pair.Key == key {
+			return pair.Value
+		}
+	}
+
+	return nil
+}
+
+// Offsets returns the offsets of the values of the dict keys, for repeated
+// keys the last one
+func (v *Value) Offsets() map[string]int64 {
+
+	offsets := make(map[string]int64, len(v.Dict))
+	for _, pair := range v.Dict {
+		offsets[pair.Key] = pair.Value.Start
+	}
+
+	return offsets
+}
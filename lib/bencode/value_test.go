@@ -0,0 +1,116 @@
+package bencode
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// offsets of the values and keys, by their path in the data
+func TestSpans(t *testing.T) {
+
+	type span struct {
+		path       string
+		keyStart   int64 // -1 for list elements and the top value
+		start, end int64
+	}
+
+	tests := []struct {
+		data  string
+		spans []span
+	}{
+		{"i42e", []span{{"", -1, 0, 4}}},
+		{"4:spam", []span{{"", -1, 0, 6}}},
+		{"l4:spami-3ee", []span{{"", -1, 0, 12}, {"0", -1, 1, 7}, {"1", -1, 7, 11}}},
+		{"d3:bar4:spam3:fooli1ei22eee", []span{
+			{"", -1, 0, 27},
+			{"bar", 1, 6, 12},
+			{"foo", 12, 17, 26},
+			{"foo/0", -1, 18, 21},
+			{"foo/1", -1, 21, 25},
+		}},
+		{"d03:fooi1ee", []span{{"", -1, 0, 11}, {"foo", 1, 7, 10}}},
+		{"ld1:ad1:bleeee", []span{{"0/a", 2, 5, 12}, {"0/a/b", 6, 9, 11}}},
+	}
+
+	for _, test := range tests {
+		v, err := Parse([]byte(test.data))
+		if err != nil {
+			t.Errorf("%q: %v", test.data, err)
+			continue
+		}
+
+		for _, want := range test.spans {
+			value, keyStart := lookup(v, want.path)
+			if value == nil {
+				t.Errorf("%q: no value at %q", test.data, want.path)
+				continue
+			}
+			if keyStart != want.keyStart || value.Start != want.start || value.End != want.end {
+				t.Errorf("%q: %q key %d value %d-%d, want key %d value %d-%d", test.data, want.path,
+					keyStart, value.Start, value.End, want.keyStart, want.start, want.end)
+			}
+			if string(value.Raw) != test.data[want.start:want.end] {
+				t.Errorf("%q: %q raw %q", test.data, want.path, value.Raw)
+			}
+		}
+	}
+
+	// the rest of the data isn't part of the value
+	v, n, err := ParsePrefix([]byte("i1ei2e"))
+	if err != nil || n != 3 || v.Start != 0 || v.End != 3 {
+		t.Errorf("prefix: %d bytes, value %d-%d, %v", n, v.Start, v.End, err)
+	}
+	if _, err := Parse([]byte("i1ei2e")); err == nil || err.(*Error).Offset != 3 {
+		t.Errorf("data after the end: %v", err)
+	}
+
+	// Get returns the first of repeated keys, Offsets the last
+	v, err = Parse([]byte("d1:ai1e1:ai2ee"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := v.Get("a"); a.Int != 1 || a.Start != 4 {
+		t.Errorf("get: %d at %d", a.Int, a.Start)
+	}
+	if offsets := v.Offsets(); offsets["a"] != 10 {
+		t.Errorf("offsets: %v", offsets)
+	}
+}
+
+// value at the path of dict keys and list indexes joined with "/", and the
+// offset of its key
+func lookup(v *Value, path string) (*Value, int64) {
+
+	keyStart := int64(-1)
+	if path == "" {
+		return v, keyStart
+	}
+
+	for _, part := range strings.Split(path, "/") {
+		switch v.Kind {
+		case Dict:
+			var next *Value
+			for _, pair := range v.Dict {
+				if pair.Key == part {
+					next, keyStart = pair.Value, pair.KeyStart
+					break
+				}
+			}
+			v = next
+		case List:
+			j, err := strconv.Atoi(part)
+			if err != nil || j >= len(v.List) {
+				return nil, -1
+			}
+			v, keyStart = v.List[j], -1
+		default:
+			return nil, -1
+		}
+		if v == nil {
+			return nil, -1
+		}
+	}
+
+	return v, keyStart
+}
@@ -0,0 +1,32 @@
+// Package seedcorpus loads the torrents captured by the crawler, used as
+// the seed corpus of the fuzz tests in lib.
+package seedcorpus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// relative to the package directories in lib, where their tests run
+const glob = "../../test/torsniff_dump.*/*/*/*.torrent"
+
+// seed torrents by path, none when the test torrents aren't there
+func Torrents(tb testing.TB) map[string][]byte {
+
+	paths, err := filepath.Glob(glob)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	seeds := make(map[string][]byte, len(paths))
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		seeds[path] = b
+	}
+
+	return seeds
+}
@@ -0,0 +1,109 @@
+package torrentparse
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BEP 47 file attributes
+const (
+	AttrPadding    = 'p'
+	AttrExecutable = 'x'
+	AttrHidden     = 'h'
+	AttrSymlink    = 'l'
+)
+
+// IsPadding reports if the file only aligns the next file to a piece
+// boundary, its content is zeros and it isn't part of the torrent data
+func (f File) IsPadding() bool {
+
+	return strings.ContainsRune(f.Attr, AttrPadding)
+}
+
+// IsExecutable reports if the file has the executable attribute
+func (f File) IsExecutable() bool {
+
+	return strings.ContainsRune(f.Attr, AttrExecutable)
+}
+
+// IsHidden reports if the file has the hidden attribute
+func (f File) IsHidden() bool {
+
+	return strings.ContainsRune(f.Attr, AttrHidden)
+}
+
+// IsSymlink reports if the file is a symlink to SymlinkPath
+func (f File) IsSymlink() bool {
+
+	return strings.ContainsRune(f.Attr, AttrSymlink)
+}
+
+// ContentFiles returns the files without padding files
+func (i *Info) ContentFiles() []File {
+
+	files := make([]File, 0, len(i.Files))
+	for _, f := range i.Files {
+		if !f.IsPadding() {
+			files = append(files, f)
+		}
+	}
+
+	return files
+}
+
+// ContentLength returns the total length of the files without padding
+func (i *Info) ContentLength() int64 {
+
+	var length int64
+	for _, f := range i.Files {
+		if !f.IsPadding() {
+			length += f.Length
+		}
+	}
+
+	return length
+}
+
+// IsPaddingPath reports if the path of a file, starting with the torrent
+// name like File.Path, is laid out like the padding files of clients
+// without BEP 47 attributes, for lists without the attributes
+func IsPaddingPath(path string) bool {
+
+	parts := strings.Split(filepath.ToSlash(path), "/")
+
+	return len(parts) > 1 && isPaddingName(parts[1:])
+}
+
+// the path below the torrent name is a ".pad/<length>" file at the top or
+// a file name of the padding files of BitComet
+func isPaddingName(parts []string) bool {
+
+	if len(parts) == 2 && parts[0] == ".pad" {
+		_, err := strconv.ParseUint(parts[1], 10, 64)
+		return err == nil
+	}
+
+	if len(parts) == 0 {
+		return false
+	}
+	name := parts[len(parts)-1]
+
+	return strings.HasPrefix(name, "_____padding_file_") || strings.HasPrefix(name, ".____padding_file")
+}
+
+// attributes of a files list entry, entries without attributes laid out
+// like padding files get the padding attribute
+func fileAttr(f file) string {
+
+	if len(f.Attr) == 0 && isPaddingName(f.Path) {
+		return string(AttrPadding)
+	}
+
+	return rawString(f.Attr)
+}
+
+func isPadding(f file) bool {
+
+	return strings.ContainsRune(fileAttr(f), AttrPadding)
+}
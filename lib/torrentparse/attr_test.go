@@ -0,0 +1,46 @@
+package torrentparse
+
+import (
+	"testing"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+)
+
+// only entries without attributes laid out like padding files are taken
+// for padding files
+func TestPaddingNames(t *testing.T) {
+
+	tests := []struct {
+		path    []string
+		attr    string // bencoded, "" for no attr key
+		padding bool
+	}{
+		{[]string{".pad", "16384"}, "", true},
+		{[]string{"_____padding_file_0_if you see this file"}, "", true},
+		{[]string{"dir", ".____padding_file_1"}, "", true},
+		{[]string{".pad", "16384"}, "1:p", true},
+		{[]string{"movie.mkv"}, "1:p", true},
+
+		{[]string{"foo", ".pad", "movie.mkv"}, "", false},
+		{[]string{".pad", "movie.mkv"}, "", false},
+		{[]string{".pad", "1", "2"}, "", false},
+		{[]string{".pad"}, "", false},
+		{[]string{"_____padding_file_0"}, "1:x", false},
+		{[]string{".pad", "16384"}, "0:", false},
+	}
+
+	for _, test := range tests {
+		f := file{Path: test.path}
+		if test.attr != "" {
+			f.Attr = bencode.RawMessage(test.attr)
+		}
+		if padding := isPadding(f); padding != test.padding {
+			t.Errorf("%q attr %q: padding %v, want %v", test.path, test.attr, padding, test.padding)
+		}
+	}
+
+	if !IsPaddingPath("name/.pad/16384") || IsPaddingPath(".pad/16384") ||
+		IsPaddingPath("name/foo/.pad/movie.mkv") {
+		t.Error("IsPaddingPath of paths starting with the torrent name")
+	}
+}
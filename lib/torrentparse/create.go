@@ -0,0 +1,277 @@
+package torrentparse
+
+import (
+	"crypto/sha1" // nolint: gosec
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+)
+
+const (
+	minPieceLength = 16 * 1024
+	maxPieceLength = 16 * 1024 * 1024
+
+	// automatic piece length aims for at most this many pieces
+	targetPieces = 1500
+)
+
+// options for building a torrent, zero values pick defaults
+type CreateOptions struct {
+	Name         string     // defaults to the base name of the path
+	PieceLength  uint32     // picked from the total size when zero
+	Trackers     [][]string // tiers, the first tracker is also the announce
+	WebSeeds     []string
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+	Private      bool
+	Source       string
+	Workers      int // parallel piece hashing, defaults to the CPU count
+}
+
+type createInfo struct {
+	Files       []file `bencode:"files,omitempty"`
+	Length      int64  `bencode:"length,omitempty"`
+	Name        string `bencode:"name"`
+	PieceLength uint32 `bencode:"piece length"`
+	Pieces      []byte `bencode:"pieces"`
+	Private     int    `bencode:"private,omitempty"`
+	Source      string `bencode:"source,omitempty"`
+}
+
+type createMetaInfo struct {
+	Announce     string             `bencode:"announce,omitempty"`
+	AnnounceList [][]string         `bencode:"announce-list,omitempty"`
+	Comment      string             `bencode:"comment,omitempty"`
+	CreatedBy    string             `bencode:"created by,omitempty"`
+	CreationDate int64              `bencode:"creation date,omitempty"`
+	Info         bencode.RawMessage `bencode:"info"`
+	URLList      []string           `bencode:"url-list,omitempty"`
+}
+
+// Create builds a v1 torrent from a file or a directory and returns the
+// bencoded metainfo, files of a directory are sorted by their path
+func Create(path string, opts CreateOptions) ([]byte, error) {
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ci := createInfo{
+		Name:   opts.Name,
+		Source: opts.Source,
+	}
+	if ci.Name == "" {
+		ci.Name = filepath.Base(filepath.Clean(path))
+	}
+	if opts.Private {
+		ci.Private = 1
+	}
+
+	var diskPaths []string
+	var total int64
+
+	if stat.IsDir() {
+		ci.Files, diskPaths, err = walkFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range ci.Files {
+			total += f.Length
+		}
+	} else {
+		ci.Length = stat.Size()
+		diskPaths = []string{path}
+		total = ci.Length
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("no data to create torrent from: %s", path)
+	}
+
+	ci.PieceLength = opts.PieceLength
+	if ci.PieceLength == 0 {
+		ci.PieceLength = autoPieceLength(total)
+	}
+
+	ci.Pieces, err = hashPieces(diskPaths, ci, total, opts.Workers)
+	if err != nil {
+		return nil, err
+	}
+
+	infoBytes, err := bencode.Marshal(ci)
+	if err != nil {
+		return nil, err
+	}
+
+	cm := createMetaInfo{
+		Comment:   opts.Comment,
+		CreatedBy: opts.CreatedBy,
+		Info:      infoBytes,
+		URLList:   opts.WebSeeds,
+	}
+	if !opts.CreationDate.IsZero() {
+		cm.CreationDate = opts.CreationDate.Unix()
+	}
+	if len(opts.Trackers) > 0 && len(opts.Trackers[0]) > 0 {
+		cm.Announce = opts.Trackers[0][0]
+		if len(opts.Trackers) > 1 || len(opts.Trackers[0]) > 1 {
+			cm.AnnounceList = opts.Trackers
+		}
+	}
+
+	return bencode.Marshal(cm)
+}
+
+// power of two piece length giving at most targetPieces pieces
+func autoPieceLength(total int64) uint32 {
+
+	pieceLength := int64(minPieceLength)
+	for pieceLength < maxPieceLength && total/pieceLength >= targetPieces {
+		pieceLength *= 2
+	}
+
+	return uint32(pieceLength)
+}
+
+// regular files of the directory in lexical order, other files are skipped
+func walkFiles(root string) ([]file, []string, error) {
+
+	var files []file
+	var diskPaths []string
+
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, file{
+			Length: info.Size(),
+			Path:   strings.Split(filepath.ToSlash(rel), "/"),
+		})
+		diskPaths = append(diskPaths, p)
+
+		return nil
+	})
+
+	return files, diskPaths, err
+}
+
+func hashPieces(diskPaths []string, ci createInfo, total int64, workers int) ([]byte, error) {
+
+	i := Info{
+		PieceLength: ci.PieceLength,
+		NumPieces:   uint32((total + int64(ci.PieceLength) - 1) / int64(ci.PieceLength)),
+		Files:       make([]File, len(diskPaths)),
+	}
+	for j, f := range ci.Files {
+		i.Files[j].Length = f.Length
+	}
+	if len(ci.Files) == 0 {
+		i.Files[0].Length = ci.Length
+	}
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	pieces := make([]byte, int(i.NumPieces)*sha1.Size)
+	piecesCh := make(chan int)
+	errCh := make(chan error, workers)
+	wg := new(sync.WaitGroup)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			files := make(map[int]*os.File)
+			defer func() {
+				for _, f := range files {
+					f.Close()
+				}
+			}()
+
+			for n := range piecesCh {
+				hash, err := hashPiece(&i, n, diskPaths, files)
+				if err != nil {
+					errCh <- err
+					return
+				}
+				copy(pieces[n*sha1.Size:], hash)
+			}
+		}()
+	}
+
+	var err error
+	for n := 0; n < int(i.NumPieces) && err == nil; n++ {
+		select {
+		case piecesCh <- n:
+		case err = <-errCh:
+		}
+	}
+	close(piecesCh)
+	wg.Wait()
+
+	if err == nil && len(errCh) > 0 {
+		err = <-errCh
+	}
+
+	return pieces, err
+}
+
+// files of the worker are closed once it's past them, its pieces come in
+// order
+func hashPiece(i *Info, n int, diskPaths []string, files map[int]*os.File) ([]byte, error) {
+
+	hash := sha1.New() // nolint: gosec
+
+	spans := i.PieceSpans(n)
+	last := spans[len(spans)-1].File
+	for j, f := range files {
+		if j < spans[0].File {
+			f.Close()
+			delete(files, j)
+		}
+	}
+
+	for _, span := range spans {
+		f, opened := files[span.File]
+		if !opened {
+			var err error
+			f, err = os.Open(diskPaths[span.File])
+			if err != nil {
+				return nil, err
+			}
+			files[span.File] = f
+		}
+
+		buf := make([]byte, span.Length)
+		if _, err := f.ReadAt(buf, span.Offset); err != nil {
+			return nil, fmt.Errorf("reading %s: %v", diskPaths[span.File], err)
+		}
+		_, _ = hash.Write(buf)
+
+		if span.File < last {
+			files[span.File].Close()
+			delete(files, span.File)
+		}
+	}
+
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,69 @@
+package torrentparse
+
+import (
+	"bytes"
+	"crypto/sha1" // nolint: gosec
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the infohash of a created torrent is the hash of its info dict, pieces
+// span the files in lexical order, more files than a worker keeps open
+func TestCreate(t *testing.T) {
+
+	rnd := rand.New(rand.NewSource(6))
+	root := filepath.Join(t.TempDir(), "data")
+
+	var data []byte
+	for j := 0; j < 500; j++ {
+		b := make([]byte, rnd.Intn(3000))
+		rnd.Read(b)
+		path := filepath.Join(root, fmt.Sprintf("%02d", j/100), fmt.Sprintf("%03d", j))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, b...)
+	}
+
+	b, err := Create(root, CreateOptions{PieceLength: 16384, Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ParseTorrent(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sha1.Sum(infoBytes(t, b)); i.Hash != InfoHash(want) { // nolint: gosec
+		t.Errorf("hash %s, want %x", i.Hash, want)
+	}
+	if i.Name != "data" || i.FilesNo != 500 || i.Files[0].Path != "data/00/000" {
+		t.Errorf("name %q, %d files, first %q", i.Name, i.FilesNo, i.Files[0].Path)
+	}
+
+	for n := 0; n < int(i.NumPieces); n++ {
+		end := (n + 1) * 16384
+		if end > len(data) {
+			end = len(data)
+		}
+		want := sha1.Sum(data[n*16384 : end]) // nolint: gosec
+		if !bytes.Equal(i.PieceHash(n), want[:]) {
+			t.Fatalf("piece %d: hash %x, want %x", n, i.PieceHash(n), want)
+		}
+	}
+
+	// created again from the same data
+	again, err := Create(root, CreateOptions{PieceLength: 16384, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(infoBytes(t, again), infoBytes(t, b)) {
+		t.Error("info dict differs when created again")
+	}
+}
@@ -0,0 +1,196 @@
+package torrentparse
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+)
+
+// returned by Encode for infos parsed with DiscardRaw
+var ErrNoInfoBytes = errors.New("torrentparse: info dict bytes not retained")
+
+// field of the metainfo dict with its current value and the value parsed
+// from the file
+type metaField struct {
+	key    string
+	value  interface{}
+	parsed interface{}
+}
+
+// Encode returns the bencoded metainfo file with the fields as they are
+// in m, the info dict is written verbatim from Info.Bytes so the infohash
+// doesn't change
+//
+// Values of unchanged fields and of keys MetaInfo doesn't model, e.g.
+// piece layers, are kept as they were in the parsed file, cleared fields
+// are removed.
+func (m *MetaInfo) Encode() ([]byte, error) {
+
+	if len(m.Info.Bytes) == 0 {
+		return nil, ErrNoInfoBytes
+	}
+
+	dict := make(map[string]bencode.RawMessage, len(m.raw)+1)
+	for key, value := range m.raw {
+		dict[key] = value
+	}
+
+	for _, f := range m.fields() {
+		key := m.rawKey(f.key)
+
+		value, err := bencode.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := bencode.Marshal(f.parsed)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case bytes.Equal(value, parsed):
+		case isZero(f.value):
+			delete(dict, key)
+		default:
+			dict[key] = value
+		}
+	}
+
+	dict["info"] = m.Info.Bytes
+
+	return bencode.Marshal(dict)
+}
+
+// SetInfoField sets the key of the info dict to value, a nil value removes
+// it, the info dict is re-encoded and parsed again with the options m was
+// parsed with, so the infohash and the warnings change
+func (m *MetaInfo) SetInfoField(key string, value interface{}) error {
+
+	if len(m.Info.Bytes) == 0 {
+		return ErrNoInfoBytes
+	}
+
+	v, err := bencode.Parse(m.Info.Bytes)
+	if err != nil {
+		return bencodeError("", 0, err)
+	}
+
+	dict := make(map[string]bencode.RawMessage, len(v.Dict)+1)
+	for _, pair := range v.Dict {
+		dict[pair.Key] = pair.Value.Raw
+	}
+
+	if value == nil {
+		delete(dict, key)
+	} else {
+		raw, err := bencode.Marshal(value)
+		if err != nil {
+			return err
+		}
+		dict[key] = raw
+	}
+
+	b, err := bencode.Marshal(dict)
+	if err != nil {
+		return err
+	}
+
+	i, err := m.opts.ParseInfo(b)
+	if err != nil {
+		return err
+	}
+
+	layers := make(map[string][]byte, len(m.Info.PieceLayers))
+	for root, layer := range m.Info.PieceLayers {
+		layers[string(root[:])] = layer
+	}
+	if err := setPieceLayers(i, layers); err != nil {
+		return err
+	}
+
+	// warnings of the metainfo file are kept, those of the info dict redone
+	var ws warnings
+	for _, w := range m.Warnings {
+		if w.Field != "info" && !strings.HasPrefix(w.Field, "info/") {
+			ws = append(ws, w)
+		}
+	}
+	m.Warnings = metaWarnings(ws, i, m.infoOffset)
+	m.Info = i
+
+	return nil
+}
+
+// SetPrivate sets or removes the BEP 27 private flag, which changes the
+// infohash
+func (m *MetaInfo) SetPrivate(private bool) error {
+
+	if private {
+		return m.SetInfoField("private", 1)
+	}
+
+	return m.SetInfoField("private", nil)
+}
+
+func (m *MetaInfo) fields() []metaField {
+
+	raw := func(key string) bencode.RawMessage {
+		return m.raw[m.rawKey(key)]
+	}
+
+	var date, parsedDate int64
+	if !m.CreationDate.IsZero() {
+		date = m.CreationDate.Unix()
+	}
+	if d, ok := rawInt(raw("creation date")); ok && d > 0 {
+		parsedDate = d
+	}
+
+	return []metaField{
+		{"announce", m.Announce, rawString(raw("announce"))},
+		{"announce-list", m.AnnounceList, rawTiers(raw("announce-list"))},
+		{"url-list", m.URLList, rawStrings(raw("url-list"))},
+		{"nodes", nodeList(m.Nodes), nodeList(rawNodes(raw("nodes")))},
+		{"creation date", date, parsedDate},
+		{"comment", m.Comment, rawString(raw("comment"))},
+		{"created by", m.CreatedBy, rawString(raw("created by"))},
+		{"encoding", m.Encoding, rawString(raw("encoding"))},
+	}
+}
+
+// the key as in the file, keys are matched ignoring case when parsing
+func (m *MetaInfo) rawKey(key string) string {
+
+	for k := range m.raw {
+		if strings.ToLower(k) == key {
+			return k
+		}
+	}
+
+	return key
+}
+
+// nodes as encoded in the metainfo file, pairs of host and port
+func nodeList(nodes []Node) [][]interface{} {
+
+	list := make([][]interface{}, 0, len(nodes))
+	for _, n := range nodes {
+		list = append(list, []interface{}{n.Host, n.Port})
+	}
+
+	return list
+}
+
+func isZero(v interface{}) bool {
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return rv.Len() == 0
+	}
+
+	return rv.IsZero()
+}
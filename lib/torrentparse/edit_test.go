@@ -0,0 +1,132 @@
+package torrentparse
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+)
+
+// edits of the outer dict keep the infohash and are read back
+func TestEncodeSeeds(t *testing.T) {
+
+	for path, b := range seedTorrents(t) {
+		m, err := ParseMetaInfo(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		hash := m.Info.Hash
+
+		m.Announce = "http://tracker.example/announce"
+		m.AnnounceList = nil
+		m.Comment = "edited"
+		m.URLList = append(m.URLList, "http://seed.example/")
+
+		out, err := m.Encode()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+
+		edited, err := ParseMetaInfo(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if edited.Info.Hash != hash {
+			t.Errorf("%s: hash %s after editing, was %s", path, edited.Info.Hash, hash)
+		}
+		if edited.Comment != m.Comment || edited.Announce != m.Announce ||
+			!reflect.DeepEqual(edited.URLList, m.URLList) {
+			t.Errorf("%s: edits not read back", path)
+		}
+	}
+}
+
+func TestSetPrivate(t *testing.T) {
+
+	for path, b := range seedTorrents(t) {
+		m, err := ParseMetaInfo(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if m.Info.Private {
+			continue
+		}
+		hash := m.Info.Hash
+
+		if err := m.SetPrivate(true); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if !m.Info.Private || m.Info.Hash == hash {
+			t.Errorf("%s: private %v, hash %s", path, m.Info.Private, m.Info.Hash)
+		}
+
+		if err := m.SetPrivate(false); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if m.Info.Private {
+			t.Errorf("%s: still private", path)
+		}
+
+		// canonical info dicts without the key get their hash back
+		if issues, err := bencode.Validate(b); err != nil || len(issues) > 0 {
+			continue
+		}
+		if v, _ := bencode.Parse(infoBytes(t, b)); v.Get("private") != nil {
+			continue
+		}
+		if m.Info.Hash != hash {
+			t.Errorf("%s: hash %s after reverting, was %s", path, m.Info.Hash, hash)
+		}
+	}
+}
+
+// the info dict is parsed again with the options of the metainfo, and its
+// warnings are redone
+func TestSetInfoFieldOptions(t *testing.T) {
+
+	b, err := bencode.Marshal(map[string]interface{}{
+		"announce": "http://t/a",
+		"info": map[string]interface{}{
+			"length":       1,
+			"name":         "t",
+			"piece length": 16384,
+			"pieces":       strings.Repeat("p", 20),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strict, err := ParseOptions{Mode: Strict}.ParseMetaInfo(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := strict.Info.Hash
+	if err := strict.SetInfoField("x-unknown", 1); !errors.Is(err, ErrStrict) {
+		t.Errorf("strict edit: %v", err)
+	}
+	if strict.Info.Hash != hash {
+		t.Error("info changed by a rejected edit")
+	}
+
+	m, err := ParseMetaInfo(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetInfoField("x-unknown", 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Warnings) != 1 || m.Warnings[0].Field != "info/x-unknown" ||
+		len(m.Info.Warnings) != 1 {
+		t.Errorf("warnings after adding a key: %v", m.Warnings)
+	}
+	if err := m.SetInfoField("x-unknown", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Warnings) != 0 || len(m.Info.Warnings) != 0 || m.Info.Hash != hash {
+		t.Errorf("warnings after removing it: %v", m.Warnings)
+	}
+}
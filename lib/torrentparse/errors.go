@@ -0,0 +1,189 @@
+package torrentparse
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+)
+
+// errors returned by ParseTorrent, ParseMetaInfo and ParseInfo wrapped in
+// a *ParseError, they can be checked with errors.Is
+var (
+	ErrDecode      = errors.New("invalid bencode")
+	ErrNoInfo      = errors.New("no info dictionary")
+	ErrPieceLength = errors.New("invalid piece length")
+	ErrPieces      = errors.New("invalid piece data")
+	ErrNoPieces    = errors.New("torrent has zero pieces")
+	ErrLength      = errors.New("total length doesn't match pieces")
+	ErrFileName    = errors.New("invalid file name")
+	ErrMetaVersion = errors.New("unsupported meta version")
+	ErrFileTree    = errors.New("invalid file tree")
+	ErrPieceLayers = errors.New("invalid piece layers")
+	ErrStrict      = errors.New("rejected in strict mode")
+	ErrLimit       = errors.New("limit exceeded")
+)
+
+// short codes of the errors above, stable for logs and statistics
+var reasons = map[error]string{
+	ErrDecode:      "decode",
+	ErrNoInfo:      "no-info",
+	ErrPieceLength: "piece-length",
+	ErrPieces:      "pieces",
+	ErrNoPieces:    "no-pieces",
+	ErrLength:      "length",
+	ErrFileName:    "file-name",
+	ErrMetaVersion: "meta-version",
+	ErrFileTree:    "file-tree",
+	ErrPieceLayers: "piece-layers",
+	ErrStrict:      "strict",
+	ErrLimit:       "limit",
+}
+
+// error with the reason, the offending field and its position
+//
+// Offset is the byte offset of the field value from the start of the data
+// given to ParseInfo or ParseMetaInfo, -1 when unknown
+type ParseError struct {
+	Err    error
+	Field  string
+	Offset int64
+	Detail string
+	Cause  error
+}
+
+func (e *ParseError) Error() string {
+
+	var b strings.Builder
+
+	b.WriteString("torrentparse: ")
+	b.WriteString(e.Err.Error())
+
+	if e.Field != "" {
+		fmt.Fprintf(&b, ": field %q", e.Field)
+		if e.Offset >= 0 {
+			fmt.Fprintf(&b, " at offset %d", e.Offset)
+		}
+	} else if e.Offset >= 0 {
+		fmt.Fprintf(&b, ": at offset %d", e.Offset)
+	}
+
+	if e.Detail != "" {
+		b.WriteString(": ")
+		b.WriteString(e.Detail)
+	}
+
+	if e.Cause != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Cause.Error())
+	}
+
+	return b.String()
+}
+
+// Is matches the sentinel error of the reason
+func (e *ParseError) Is(target error) bool {
+
+	return e.Err == target
+}
+
+// Unwrap returns the underlying error, e.g. from the bencode decoder
+func (e *ParseError) Unwrap() error {
+
+	return e.Cause
+}
+
+// Reason returns the short code of the error, e.g. "piece-length"
+func (e *ParseError) Reason() string {
+
+	return reasons[e.Err]
+}
+
+// Reasons returns the short codes ReasonOf returns, sorted
+func Reasons() []string {
+
+	codes := []string{"other"}
+	for _, reason := range reasons {
+		codes = append(codes, reason)
+	}
+	sort.Strings(codes)
+
+	return codes
+}
+
+// ReasonOf returns the short code of a parse error or "other"
+func ReasonOf(err error) string {
+
+	var pe *ParseError
+	if errors.As(err, &pe) {
+		if reason := pe.Reason(); reason != "" {
+			return reason
+		}
+	}
+
+	return "other"
+}
+
+func newError(err error, field string, detail string) *ParseError {
+
+	return &ParseError{Err: err, Field: field, Offset: -1, Detail: detail}
+}
+
+// converts errors of the bencode package to ErrDecode or ErrLimit, base is
+// the offset of the data they are about, other errors are returned as they are
+func bencodeError(field string, base int64, err error) error {
+
+	be, ok := err.(*bencode.Error)
+	if !ok {
+		return err
+	}
+
+	e := &ParseError{Err: ErrDecode, Field: field, Offset: base + be.Offset, Cause: be.Err}
+	switch {
+	case errors.Is(be.Err, bencode.ErrLimit):
+		e.Err, e.Cause, e.Detail = ErrLimit, nil, be.Msg
+	case be.Err == nil:
+		e.Detail = be.Msg
+	}
+
+	return e
+}
+
+// fills in the offset of the field, b is a bencoded dict, base its offset
+func locateError(err error, b []byte, base int64) error {
+
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		return err
+	}
+
+	if pe.Offset >= 0 {
+		pe.Offset += base
+		return err
+	}
+
+	v, _, decodeErr := bencode.ParsePrefix(b)
+	if decodeErr != nil {
+		return err
+	}
+
+	return locateKey(err, v.Offsets(), base)
+}
+
+// like locateError with the offsets of the dict keys
+func locateKey(err error, offsets map[string]int64, base int64) error {
+
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Offset >= 0 {
+		return err
+	}
+
+	key := strings.SplitN(pe.Field, "/", 2)[0]
+	if offset, exists := offsets[key]; exists {
+		pe.Offset = base + offset
+	}
+
+	return err
+}
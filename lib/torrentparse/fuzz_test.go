@@ -0,0 +1,260 @@
+package torrentparse
+
+import (
+	"bytes"
+	"crypto/sha1" // nolint: gosec
+	"crypto/sha256"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+	"github.com/torrentdb/torrent_utils/lib/internal/seedcorpus"
+)
+
+// the tests of the whole torrents are skipped without seed torrents
+func seedTorrents(tb testing.TB) map[string][]byte {
+
+	seeds := seedcorpus.Torrents(tb)
+	if len(seeds) == 0 {
+		tb.Skip("no seed torrents")
+	}
+
+	return seeds
+}
+
+// the bytes of the info dict of a metainfo file
+func infoBytes(tb testing.TB, b []byte) []byte {
+
+	v, err := bencode.Parse(b)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	info := v.Get("info")
+	if info == nil {
+		tb.Fatal("no info dict")
+	}
+
+	return info.Raw
+}
+
+func FuzzParseTorrent(f *testing.F) {
+
+	for _, b := range seedTorrents(f) {
+		f.Add(b)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+
+		i, err := ParseTorrent(bytes.NewReader(data))
+		if err != nil {
+			checkError(t, err, len(data))
+			return
+		}
+		checkInfo(t, i)
+
+		// the streaming decoder hashes the same bytes it keeps
+		lean, err := ParseOptions{DiscardRaw: true}.ParseTorrent(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed with DiscardRaw: %v", err)
+		}
+		if lean.Hash != i.Hash || lean.HashV2 != i.HashV2 {
+			t.Fatalf("hash %s with DiscardRaw, %s without", lean.Hash, i.Hash)
+		}
+		if lean.Length != i.Length || lean.NumPieces != i.NumPieces {
+			t.Fatalf("length %d, %d pieces with DiscardRaw, %d, %d without",
+				lean.Length, lean.NumPieces, i.Length, i.NumPieces)
+		}
+
+		again, err := ParseInfo(i.Bytes)
+		if err != nil {
+			t.Fatalf("ParseInfo of Info.Bytes: %v", err)
+		}
+		if again.Hash != i.Hash {
+			t.Fatalf("hash %s from ParseInfo, %s from ParseTorrent", again.Hash, i.Hash)
+		}
+	})
+}
+
+func FuzzParseInfo(f *testing.F) {
+
+	for _, b := range seedTorrents(f) {
+		f.Add(infoBytes(f, b))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+
+		i, err := ParseInfo(data)
+		if err != nil {
+			checkError(t, err, len(data))
+			return
+		}
+		if !bytes.Equal(i.Bytes, data) {
+			t.Fatalf("Info.Bytes differs from the data")
+		}
+		checkInfo(t, i)
+	})
+}
+
+// errors are typed and point inside the data
+func checkError(t *testing.T, err error, size int) {
+
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("error of type %T: %v", err, err)
+	}
+	if pe.Reason() == "" {
+		t.Fatalf("error without a reason: %v", err)
+	}
+	if pe.Offset > int64(size) {
+		t.Fatalf("offset %d outside of %d bytes: %v", pe.Offset, size, err)
+	}
+}
+
+// invariants of every parsed torrent, the v1 ones are those enforced by
+// the length check of parseV1
+func checkInfo(t *testing.T, i *Info) {
+
+	if i.HasV1() && i.Hash != sha1.Sum(i.Bytes) { // nolint: gosec
+		t.Fatalf("hash %s is not the SHA-1 of Info.Bytes", i.Hash)
+	}
+	if i.HasV2() && i.HashV2 != sha256.Sum256(i.Bytes) {
+		t.Fatalf("v2 hash %s is not the SHA-256 of Info.Bytes", i.HashV2)
+	}
+	if i.HashStr != i.Hash.String() || i.HashInt != i.Hash.Int() {
+		t.Fatalf("hash strings don't match hash %s", i.Hash)
+	}
+
+	if i.FilesNo != len(i.Files) || len(i.Files) == 0 {
+		t.Fatalf("FilesNo %d for %d files", i.FilesNo, len(i.Files))
+	}
+
+	var length int64
+	for _, f := range i.Files {
+		if f.Length < 0 {
+			t.Fatalf("file %q of negative length %d", f.Path, f.Length)
+		}
+		length += f.Length
+	}
+	if length != i.Length {
+		t.Fatalf("files add up to %d bytes, Length is %d", length, i.Length)
+	}
+
+	if !i.HasV1() {
+		return
+	}
+
+	pieceLength := int64(i.PieceLength)
+	delta := pieceLength*int64(i.NumPieces) - i.Length
+	if delta < 0 || delta >= pieceLength {
+		t.Fatalf("%d pieces of %d bytes for %d bytes", i.NumPieces, i.PieceLength, i.Length)
+	}
+
+	// spans of the first and the last piece cover them
+	for _, n := range []int{0, int(i.NumPieces) - 1} {
+		want := pieceLength
+		if n == int(i.NumPieces)-1 {
+			want = i.Length - int64(n)*pieceLength
+		}
+		var got int64
+		for _, span := range i.PieceSpans(n) {
+			got += span.Length
+		}
+		if got != want {
+			t.Fatalf("spans of piece %d cover %d bytes, want %d", n, got, want)
+		}
+		if len(i.PieceHash(n)) != sha1.Size {
+			t.Fatalf("no hash of piece %d", n)
+		}
+	}
+}
+
+// re-encoding a canonical info dict gives the same bytes and infohash
+func TestReencodeSeeds(t *testing.T) {
+
+	for path, b := range seedTorrents(t) {
+		i, err := ParseTorrent(bytes.NewReader(b))
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+
+		v, err := bencode.Parse(i.Bytes)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if len(v.Validate()) > 0 {
+			continue
+		}
+
+		enc, err := bencode.Marshal(v.Interface())
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+
+		again, err := ParseInfo(enc)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if again.Hash != i.Hash {
+			t.Errorf("%s: hash %s after re-encoding, was %s", path, again.Hash, i.Hash)
+		}
+	}
+}
+
+// torrents built by Create parse back to the same data and verify
+func TestCreateParse(t *testing.T) {
+
+	rnd := rand.New(rand.NewSource(1))
+
+	for _, sizes := range [][]int{{1}, {16384}, {16385}, {0, 5, 40000}, {70000, 1, 16383}} {
+		dir := t.TempDir()
+		root := filepath.Join(dir, "data")
+
+		var total int64
+		for j, size := range sizes {
+			data := make([]byte, size)
+			rnd.Read(data)
+			path := filepath.Join(root, "sub", string(rune('a'+j)))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			total += int64(size)
+		}
+
+		b, err := Create(root, CreateOptions{PieceLength: 16384, Trackers: [][]string{{"http://t/a"}}})
+		if err != nil {
+			t.Fatalf("%v: %v", sizes, err)
+		}
+
+		i, err := ParseOptions{Mode: Strict}.ParseTorrent(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%v: %v", sizes, err)
+		}
+		checkInfo(t, i)
+
+		if !bytes.Equal(i.Bytes, infoBytes(t, b)) {
+			t.Fatalf("%v: Info.Bytes differs from the info dict", sizes)
+		}
+		if i.Length != total || i.FilesNo != len(sizes) {
+			t.Fatalf("%v: %d bytes in %d files", sizes, i.Length, i.FilesNo)
+		}
+
+		res, err := i.Verify(dir)
+		if err != nil {
+			t.Fatalf("%v: %v", sizes, err)
+		}
+		if !res.Complete() {
+			t.Fatalf("%v: bad pieces %v", sizes, res.BadPieces)
+		}
+	}
+}
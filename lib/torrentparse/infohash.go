@@ -0,0 +1,145 @@
+package torrentparse
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	urnV1 = "urn:btih:"
+	urnV2 = "urn:btmh:"
+)
+
+// v2 infohashes in magnet links are multihashes: sha2-256 code and length
+var multihashSha256 = []byte{0x12, 0x20}
+
+// SHA-1 infohash, or the truncated SHA-256 infohash of a v2-only torrent
+type InfoHash [20]byte
+
+// SHA-256 infohash of a v2 or hybrid torrent
+type InfoHashV2 [32]byte
+
+// String returns the infohash as 40 lower case hex digits
+func (h InfoHash) String() string {
+
+	return hex.EncodeToString(h[:])
+}
+
+// Base32 returns the infohash as 32 base32 characters used by old magnets
+func (h InfoHash) Base32() string {
+
+	return base32.StdEncoding.EncodeToString(h[:])
+}
+
+// URN returns the infohash in magnet link form, urn:btih:<hex>
+func (h InfoHash) URN() string {
+
+	return urnV1 + h.String()
+}
+
+// Int returns the first 8 bytes of the infohash as a number
+func (h InfoHash) Int() uint64 {
+
+	return binary.BigEndian.Uint64(h[:8])
+}
+
+// Short returns the first 8 bytes of the infohash as 16 hex digits
+func (h InfoHash) Short() string {
+
+	return hex.EncodeToString(h[:8])
+}
+
+// IsZero reports if the infohash is all zeros
+func (h InfoHash) IsZero() bool {
+
+	return h == InfoHash{}
+}
+
+// ParseInfoHash accepts hex or base32 infohashes, optionally as urn:btih:
+func ParseInfoHash(s string) (InfoHash, error) {
+
+	var h InfoHash
+
+	s = strings.TrimSpace(s)
+	if len(s) > len(urnV1) && strings.EqualFold(s[:len(urnV1)], urnV1) {
+		s = s[len(urnV1):]
+	}
+
+	var b []byte
+	var err error
+
+	switch len(s) {
+	case hex.EncodedLen(len(h)):
+		b, err = hex.DecodeString(s)
+	case base32.StdEncoding.EncodedLen(len(h)):
+		b, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	default:
+		return h, fmt.Errorf("invalid infohash length: %q", s)
+	}
+	if err != nil {
+		return h, fmt.Errorf("invalid infohash: %q", s)
+	}
+
+	copy(h[:], b)
+
+	return h, nil
+}
+
+// String returns the v2 infohash as 64 lower case hex digits
+func (h InfoHashV2) String() string {
+
+	return hex.EncodeToString(h[:])
+}
+
+// URN returns the v2 infohash in magnet link form, urn:btmh:<multihash>
+func (h InfoHashV2) URN() string {
+
+	return urnV2 + hex.EncodeToString(multihashSha256) + h.String()
+}
+
+// Truncated returns the first 20 bytes used to identify v2 torrents in v1 APIs
+func (h InfoHashV2) Truncated() InfoHash {
+
+	var t InfoHash
+	copy(t[:], h[:])
+
+	return t
+}
+
+// IsZero reports if the infohash is all zeros
+func (h InfoHashV2) IsZero() bool {
+
+	return h == InfoHashV2{}
+}
+
+// ParseInfoHashV2 accepts hex v2 infohashes, optionally as a multihash or
+// urn:btmh:
+func ParseInfoHashV2(s string) (InfoHashV2, error) {
+
+	var h InfoHashV2
+
+	s = strings.TrimSpace(s)
+	if len(s) > len(urnV2) && strings.EqualFold(s[:len(urnV2)], urnV2) {
+		s = s[len(urnV2):]
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return h, fmt.Errorf("invalid v2 infohash: %q", s)
+	}
+
+	if len(b) == len(multihashSha256)+len(h) &&
+		b[0] == multihashSha256[0] && b[1] == multihashSha256[1] {
+		b = b[len(multihashSha256):]
+	}
+	if len(b) != len(h) {
+		return h, fmt.Errorf("invalid v2 infohash length: %q", s)
+	}
+
+	copy(h[:], b)
+
+	return h, nil
+}
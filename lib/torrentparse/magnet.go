@@ -0,0 +1,146 @@
+package torrentparse
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// contents of a magnet link
+//
+// InfoHash of v2-only torrents is the truncated InfoHashV2, links for them
+// carry only the btmh hash
+type Magnet struct {
+	InfoHash   InfoHash
+	InfoHashV2 InfoHashV2
+	Name       string
+	Length     int64
+	Trackers   []string
+	WebSeeds   []string
+}
+
+// Magnet returns the magnet link of the torrent without trackers
+func (i *Info) Magnet() *Magnet {
+
+	m := Magnet{
+		InfoHash: i.Hash,
+		Name:     i.Name,
+		Length:   i.Length,
+	}
+	if i.HasV2() {
+		m.InfoHashV2 = i.HashV2
+	}
+
+	return &m
+}
+
+// Magnet returns the magnet link of the torrent with trackers and web seeds
+func (m *MetaInfo) Magnet() *Magnet {
+
+	magnet := m.Info.Magnet()
+	magnet.Trackers = m.TrackerList()
+	magnet.WebSeeds = m.URLList
+
+	return magnet
+}
+
+func (m *Magnet) hasV1() bool {
+
+	return m.InfoHashV2.IsZero() || m.InfoHashV2.Truncated() != m.InfoHash
+}
+
+// String returns the magnet:? URI
+func (m *Magnet) String() string {
+
+	var params []string
+
+	if m.hasV1() {
+		params = append(params, "xt="+m.InfoHash.URN())
+	}
+	if !m.InfoHashV2.IsZero() {
+		params = append(params, "xt="+m.InfoHashV2.URN())
+	}
+	if m.Name != "" {
+		params = append(params, "dn="+queryEscape(m.Name))
+	}
+	if m.Length > 0 {
+		params = append(params, "xl="+strconv.FormatInt(m.Length, 10))
+	}
+	for _, tracker := range m.Trackers {
+		params = append(params, "tr="+queryEscape(tracker))
+	}
+	for _, webSeed := range m.WebSeeds {
+		params = append(params, "ws="+queryEscape(webSeed))
+	}
+
+	return "magnet:?" + strings.Join(params, "&")
+}
+
+// spaces as %20, plus signs are read as spaces by some clients
+func queryEscape(s string) string {
+
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// ParseMagnet parses magnet links with btih and/or btmh exact topics
+func ParseMagnet(uri string) (*Magnet, error) {
+
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %v", err)
+	}
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("invalid magnet link scheme: %q", u.Scheme)
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %v", err)
+	}
+
+	var m Magnet
+	var v1, v2 bool
+
+	for key, values := range query {
+		// numbered parameters, e.g. xt.1, are used for multiple topics
+		key = strings.SplitN(key, ".", 2)[0]
+
+		for _, value := range values {
+			switch {
+			case key == "xt" && hasPrefixFold(value, urnV1):
+				if m.InfoHash, err = ParseInfoHash(value); err != nil {
+					return nil, err
+				}
+				v1 = true
+			case key == "xt" && hasPrefixFold(value, urnV2):
+				if m.InfoHashV2, err = ParseInfoHashV2(value); err != nil {
+					return nil, err
+				}
+				v2 = true
+			case key == "dn":
+				m.Name = value
+			case key == "xl":
+				m.Length, _ = strconv.ParseInt(value, 10, 64)
+			case key == "tr":
+				m.Trackers = append(m.Trackers, value)
+			case key == "ws":
+				m.WebSeeds = append(m.WebSeeds, value)
+			}
+		}
+	}
+
+	if !v1 && !v2 {
+		return nil, fmt.Errorf("no btih or btmh infohash in magnet link")
+	}
+	if !v1 {
+		m.InfoHash = m.InfoHashV2.Truncated()
+	}
+
+	return &m, nil
+}
+
+func hasPrefixFold(s, prefix string) bool {
+
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
@@ -0,0 +1,298 @@
+package torrentparse
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+)
+
+// information about torrent from the whole metainfo file, fields outside of
+// the info dictionary are optional and malformed ones are left empty
+type MetaInfo struct {
+	Info         *Info
+	Announce     string
+	AnnounceList [][]string // BEP 12 tracker tiers
+	URLList      []string   // BEP 19 web seeds
+	Nodes        []Node     // BEP 5 DHT bootstrap nodes
+	CreationDate time.Time
+	Comment      string
+	CreatedBy    string
+	Encoding     string
+	Warnings     []Warning                     // including the Info warnings under "info/"
+	raw          map[string]bencode.RawMessage // values besides info, keys as in the file
+	opts         ParseOptions                  // the info dict is parsed with after edits
+	infoOffset   int64
+}
+
+// DHT node from the metainfo nodes list
+type Node struct {
+	Host string
+	Port int
+}
+
+// String returns the node as host:port
+func (n Node) String() string {
+
+	return net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+}
+
+// reads the metainfo file as a stream, values other than the info dict
+// are kept raw and decoded leniently
+func (o ParseOptions) ParseMetaInfo(r io.Reader) (*MetaInfo, error) {
+
+	s := bencode.NewReader(r)
+	s.Limit = o.MaxSize
+
+	var unknown warnings
+
+	raw := make(map[string]bencode.RawMessage)
+	outer := make(map[string]bencode.RawMessage)
+	keys := make(map[string]int64)
+
+	var info *Info
+	var infoOffset int64
+	var infoErr error
+	var layerLengths map[string]int // by pieces root, with DiscardRaw
+
+	err := s.ReadDict(func(key string) error {
+		offset := s.Offset()
+		keys[key] = offset
+		if !knownMetaKeys[key] {
+			unknown.add(WarnUnknownKey, key, offset, "")
+		}
+
+		// keys are matched like when decoding the whole dict
+		// errors of the info values are reported after the whole file is
+		// decoded, a truncated file is a decode error
+		if strings.ToLower(key) == "info" {
+			infoOffset = offset
+			info, infoErr = o.streamInfo(s)
+			if errors.Is(infoErr, ErrDecode) || errors.Is(infoErr, ErrLimit) {
+				return infoErr
+			}
+			return nil
+		}
+
+		if strings.ToLower(key) == "piece layers" && o.DiscardRaw {
+			if c, err := s.Peek(); err == nil && c == 'd' {
+				delete(raw, "piece layers")
+				layerLengths = make(map[string]int)
+				return countPieceLayers(s, layerLengths)
+			}
+		}
+
+		value, err := s.ReadValue()
+		raw[strings.ToLower(key)] = value
+		outer[key] = value
+
+		return err
+	})
+	if err != nil {
+		return nil, bencodeError("", 0, err)
+	}
+	s.End()
+
+	if infoErr != nil {
+		return nil, infoErr
+	}
+	if info == nil {
+		return nil, newError(ErrNoInfo, "info", "")
+	}
+
+	var pieceLayers map[string][]byte
+	if value, exists := raw["piece layers"]; exists {
+		if err := bencode.Unmarshal(value, &pieceLayers); err != nil {
+			return nil, bencodeError("piece layers", keys["piece layers"], err)
+		}
+	}
+
+	if layerLengths != nil {
+		err = checkPieceLayers(info, layerLengths)
+	} else {
+		err = setPieceLayers(info, pieceLayers)
+	}
+	if err != nil {
+		return nil, locateKey(err, keys, 0)
+	}
+
+	m := MetaInfo{
+		Info:         info,
+		Announce:     rawString(raw["announce"]),
+		AnnounceList: rawTiers(raw["announce-list"]),
+		URLList:      rawStrings(raw["url-list"]),
+		Nodes:        rawNodes(raw["nodes"]),
+		Comment:      rawString(raw["comment"]),
+		CreatedBy:    rawString(raw["created by"]),
+		Encoding:     rawString(raw["encoding"]),
+		raw:          outer,
+		opts:         o,
+		infoOffset:   infoOffset,
+	}
+
+	if date, ok := rawInt(raw["creation date"]); ok && date > 0 {
+		m.CreationDate = time.Unix(date, 0).UTC()
+	}
+
+	var ws warnings
+	issueWarnings(&ws, s.Issues)
+	for _, w := range unknown {
+		ws.add(w.Kind, w.Field, w.Offset, w.Detail)
+	}
+	m.Warnings = metaWarnings(ws, info, infoOffset)
+	if err := o.checkWarnings(m.Warnings); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// the layers are only read for their lengths, by pieces root, -1 for the
+// values that aren't strings
+func countPieceLayers(s *bencode.Reader, lengths map[string]int) error {
+
+	return s.ReadDict(func(root string) error {
+		c, err := s.Peek()
+		if err != nil {
+			return err
+		}
+
+		if c < '0' || c > '9' {
+			lengths[root] = -1
+			_, err := s.ReadValue()
+			return err
+		}
+
+		n, err := s.ReadString(io.Discard)
+		lengths[root] = int(n)
+		return err
+	})
+}
+
+// Trackers returns the BEP 12 tiers, or the announce URL as the only tier
+func (m *MetaInfo) Trackers() [][]string {
+
+	if len(m.AnnounceList) > 0 {
+		return m.AnnounceList
+	}
+
+	if m.Announce != "" {
+		return [][]string{{m.Announce}}
+	}
+
+	return nil
+}
+
+// TrackerList returns all tracker URLs in tier order without duplicates
+func (m *MetaInfo) TrackerList() []string {
+
+	var list []string
+	seen := make(map[string]bool)
+
+	for _, tier := range m.Trackers() {
+		for _, tracker := range tier {
+			if seen[tracker] {
+				continue
+			}
+			seen[tracker] = true
+			list = append(list, tracker)
+		}
+	}
+
+	return list
+}
+
+func rawDecode(raw bencode.RawMessage) interface{} {
+
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var v interface{}
+	if err := bencode.Unmarshal(raw, &v); err != nil {
+		return nil
+	}
+
+	return v
+}
+
+func rawString(raw bencode.RawMessage) string {
+
+	s, _ := rawDecode(raw).(string)
+
+	return s
+}
+
+func rawInt(raw bencode.RawMessage) (int64, bool) {
+
+	i, ok := rawDecode(raw).(int64)
+
+	return i, ok
+}
+
+// a single string or a list of strings, as used by url-list
+func rawStrings(raw bencode.RawMessage) []string {
+
+	switch v := rawDecode(raw).(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []interface{}:
+		return stringList(v)
+	}
+
+	return nil
+}
+
+func rawTiers(raw bencode.RawMessage) [][]string {
+
+	list, _ := rawDecode(raw).([]interface{})
+
+	var tiers [][]string
+	for _, item := range list {
+		tierList, _ := item.([]interface{})
+		if tier := stringList(tierList); len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+
+	return tiers
+}
+
+func rawNodes(raw bencode.RawMessage) []Node {
+
+	list, _ := rawDecode(raw).([]interface{})
+
+	var nodes []Node
+	for _, item := range list {
+		pair, _ := item.([]interface{})
+		if len(pair) != 2 {
+			continue
+		}
+		host, hostOk := pair[0].(string)
+		port, portOk := pair[1].(int64)
+		if !hostOk || !portOk || host == "" || port <= 0 || port > 65535 {
+			continue
+		}
+		nodes = append(nodes, Node{Host: host, Port: int(port)})
+	}
+
+	return nodes
+}
+
+func stringList(list []interface{}) []string {
+
+	var strs []string
+	for _, item := range list {
+		if s, ok := item.(string); ok && s != "" {
+			strs = append(strs, s)
+		}
+	}
+
+	return strs
+}
@@ -0,0 +1,158 @@
+package torrentparse
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+)
+
+// how defects that don't prevent using the torrent are handled
+type ParseMode int
+
+const (
+	// keep the torrent and report defects in Warnings
+	Lenient ParseMode = iota
+	// reject the torrent on the first defect with ErrStrict
+	Strict
+)
+
+// options of ParseTorrent, ParseMetaInfo and ParseInfo, the zero value is
+// the lenient mode used by the package level functions
+type ParseOptions struct {
+	Mode ParseMode
+
+	// sanitizes paths of the files, nil keeps them as they are and rejects
+	// only ".." components
+	Paths *PathPolicy
+
+	// limits rejecting the torrent with ErrLimit, zero is no limit, the
+	// metainfo file is read only up to MaxSize bytes
+	MaxSize      int64 // bytes of the metainfo file or of the info dict
+	MaxFiles     int
+	MaxPathDepth int // directories and the file name below the torrent name
+
+	// don't keep Info.Bytes, the v1 piece hashes and the v2 piece layers,
+	// the memory is released while parsing, PieceHash and Verify need them
+	DiscardRaw bool
+}
+
+func ParseTorrent(r io.Reader) (*Info, error) {
+
+	return ParseOptions{}.ParseTorrent(r)
+}
+
+// ParseMetaInfo parses the whole metainfo file, including the info dictionary
+func ParseMetaInfo(r io.Reader) (*MetaInfo, error) {
+
+	return ParseOptions{}.ParseMetaInfo(r)
+}
+
+func ParseInfo(b []byte) (*Info, error) {
+
+	return ParseOptions{}.ParseInfo(b)
+}
+
+func (o ParseOptions) ParseTorrent(r io.Reader) (*Info, error) {
+
+	m, err := o.ParseMetaInfo(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Info, nil
+}
+
+func (o ParseOptions) ParseInfo(b []byte) (*Info, error) {
+
+	i, err := o.readInfo(b)
+	if err == nil {
+		err = o.checkWarnings(i.Warnings)
+	}
+	if err != nil {
+		return nil, locateError(err, b, 0)
+	}
+
+	return i, nil
+}
+
+// parses the info dictionary, the files list is counted before decoding
+func (o ParseOptions) readInfo(b []byte) (*Info, error) {
+
+	if o.MaxSize > 0 && int64(len(b)) > o.MaxSize {
+		return nil, newError(ErrLimit, "", fmt.Sprintf("%d bytes, limit %d", len(b), o.MaxSize))
+	}
+
+	v, _, err := bencode.ParsePrefix(b)
+	if err != nil {
+		return nil, bencodeError("", 0, err)
+	}
+
+	if files := v.Get("files"); files != nil && o.MaxFiles > 0 && len(files.List) > o.MaxFiles {
+		return nil, o.filesLimitError("files")
+	}
+
+	i, err := parseInfo(b, v)
+	if err != nil {
+		return nil, err
+	}
+
+	return i, o.finishInfo(i)
+}
+
+// checks the limits, applies the path policy and drops the raw data
+func (o ParseOptions) finishInfo(i *Info) error {
+
+	if o.MaxFiles > 0 && len(i.Files) > o.MaxFiles {
+		field := "file tree"
+		if _, multiFile := i.keys["files"]; multiFile && i.HasV1() {
+			field = "files"
+		}
+		return o.filesLimitError(field)
+	}
+
+	if o.MaxPathDepth > 0 {
+		_, multiFile := i.keys["files"]
+		for j, p := range i.paths {
+			if depth := len(p) - 1; depth > o.MaxPathDepth {
+				return newError(ErrLimit, pathField(i, j, multiFile),
+					fmt.Sprintf("path depth %d, limit %d", depth, o.MaxPathDepth))
+			}
+		}
+	}
+
+	if o.Paths != nil {
+		if err := o.Paths.sanitizeFiles(i); err != nil {
+			return err
+		}
+	}
+
+	if o.DiscardRaw {
+		i.Bytes = nil
+		i.pieces = nil
+	}
+
+	return nil
+}
+
+func (o ParseOptions) filesLimitError(field string) *ParseError {
+
+	return newError(ErrLimit, field, fmt.Sprintf("more than %d files", o.MaxFiles))
+}
+
+// in strict mode the first warning is returned as an error
+func (o ParseOptions) checkWarnings(warnings []Warning) error {
+
+	if o.Mode != Strict || len(warnings) == 0 {
+		return nil
+	}
+
+	w := warnings[0]
+
+	detail := w.Kind
+	if w.Detail != "" {
+		detail += ": " + w.Detail
+	}
+
+	return &ParseError{Err: ErrStrict, Field: w.Field, Offset: w.Offset, Detail: detail}
+}
@@ -0,0 +1,406 @@
+package torrentparse
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// classification of a file path by PathPolicy.Sanitize
+type PathStatus int
+
+const (
+	// the path can be used as it is
+	PathSafe PathStatus = iota
+	// the path was changed to be safe, see PathResult.Changes
+	PathFixed
+	// the path can't be made safe, e.g. it has a ".." component
+	PathRejected
+)
+
+var pathStatusNames = []string{"safe", "fixed", "rejected"}
+
+func (s PathStatus) String() string {
+
+	if s < 0 || int(s) >= len(pathStatusNames) {
+		return fmt.Sprint("PathStatus(", int(s), ")")
+	}
+
+	return pathStatusNames[s]
+}
+
+// rules for file paths of torrents, the zero value is the portable policy
+// used for writing files on Unix and Windows
+type PathPolicy struct {
+	MaxNameLength int    // bytes of a path component, 255 when zero
+	MaxPathLength int    // bytes of the whole path, 4096 when zero
+	Replacement   string // replaces invalid characters, "_" when empty
+	Unix          bool   // skip Windows reserved names and characters
+}
+
+// a single change made to a path component
+type PathChange struct {
+	Part   int // index in the original components
+	Reason string
+	From   string
+	To     string // empty for dropped components
+}
+
+// result of PathPolicy.Sanitize, Parts and Path are empty for rejected paths
+type PathResult struct {
+	Status  PathStatus
+	Parts   []string
+	Path    string
+	Changes []PathChange
+	Reason  string // why the path was rejected
+	last    int    // original component of the last part
+}
+
+// characters Windows doesn't allow in file names, besides control characters
+const windowsReserved = `<>:"|?*`
+
+// Sanitize classifies the path made of parts, e.g. the torrent name followed
+// by the path of a file, and normalises it
+//
+// Invalid UTF-8, path separators, control characters and on Windows also
+// reserved characters are replaced, trailing dots and spaces are trimmed on
+// Windows, reserved device names are prefixed, empty and "." components are
+// dropped, leading separators and drive letters are removed and overlong
+// names are shortened keeping the extension. Paths with NUL bytes or ".."
+// components, empty paths and overlong paths are rejected.
+func (p PathPolicy) Sanitize(parts []string) PathResult {
+
+	var res PathResult
+
+	for j, part := range parts {
+		if strings.IndexByte(part, 0) >= 0 {
+			return rejectPath(res, fmt.Sprintf("NUL byte in part %d", j))
+		}
+		if strings.TrimSpace(part) == ".." {
+			return rejectPath(res, fmt.Sprintf(`".." in part %d`, j))
+		}
+
+		fixed := part
+		if j == 0 {
+			fixed = p.fix(&res, j, fixed, "absolute path", trimAbsolute)
+		}
+		fixed = p.fix(&res, j, fixed, "invalid UTF-8", cleanName)
+		fixed = p.fix(&res, j, fixed, "path separator", p.replaceSeparators)
+		fixed = p.fix(&res, j, fixed, "control character", p.replaceControl)
+		if !p.Unix {
+			fixed = p.fix(&res, j, fixed, "reserved character", p.replaceWindows)
+			fixed = p.fix(&res, j, fixed, "trailing dot or space", trimWindows)
+		}
+
+		if fixed == "" || fixed == "." {
+			res.Changes = append(res.Changes, PathChange{Part: j, Reason: "empty component", From: part})
+			continue
+		}
+		if fixed == ".." {
+			return rejectPath(res, fmt.Sprintf(`".." in part %d`, j))
+		}
+
+		if !p.Unix {
+			fixed = p.fix(&res, j, fixed, "reserved name", prefixReserved)
+		}
+		fixed = p.fix(&res, j, fixed, "name too long", p.shorten)
+
+		res.Parts = append(res.Parts, fixed)
+		res.last = j
+	}
+
+	if len(res.Parts) == 0 {
+		return rejectPath(res, "empty path")
+	}
+
+	res.Path = filepath.Join(res.Parts...)
+	if len(res.Path) > p.maxPath() {
+		return rejectPath(res, fmt.Sprintf("path of %d bytes", len(res.Path)))
+	}
+
+	if len(res.Changes) > 0 {
+		res.Status = PathFixed
+	}
+
+	return res
+}
+
+// applies fix to the component and records the change
+func (p PathPolicy) fix(res *PathResult, j int, s string, reason string,
+	fix func(string) string) string {
+
+	fixed := fix(s)
+	if fixed != s {
+		res.Changes = append(res.Changes, PathChange{Part: j, Reason: reason, From: s, To: fixed})
+	}
+
+	return fixed
+}
+
+func rejectPath(res PathResult, reason string) PathResult {
+
+	return PathResult{Status: PathRejected, Changes: res.Changes, Reason: reason}
+}
+
+func (p PathPolicy) maxName() int {
+
+	if p.MaxNameLength <= 0 {
+		return 255
+	}
+
+	return p.MaxNameLength
+}
+
+func (p PathPolicy) maxPath() int {
+
+	if p.MaxPathLength <= 0 {
+		return 4096
+	}
+
+	return p.MaxPathLength
+}
+
+func (p PathPolicy) replacement() string {
+
+	if p.Replacement == "" {
+		return "_"
+	}
+
+	return p.Replacement
+}
+
+func (p PathPolicy) replaceSeparators(s string) string {
+
+	return strings.NewReplacer("/", p.replacement(), `\`, p.replacement()).Replace(s)
+}
+
+func (p PathPolicy) replaceControl(s string) string {
+
+	return p.replaceFunc(s, unicode.IsControl)
+}
+
+func (p PathPolicy) replaceWindows(s string) string {
+
+	return p.replaceFunc(s, func(c rune) bool {
+		return strings.ContainsRune(windowsReserved, c)
+	})
+}
+
+func (p PathPolicy) replaceFunc(s string, invalid func(rune) bool) string {
+
+	if strings.IndexFunc(s, invalid) < 0 {
+		return s
+	}
+
+	var b strings.Builder
+	for _, c := range s {
+		if invalid(c) {
+			b.WriteString(p.replacement())
+		} else {
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String()
+}
+
+// removes leading separators and a drive letter, e.g. "C:\"
+func trimAbsolute(s string) string {
+
+	if len(s) >= 2 && s[1] == ':' && unicode.IsLetter(rune(s[0])) {
+		s = s[2:]
+	}
+
+	return strings.TrimLeft(s, `/\`)
+}
+
+func trimWindows(s string) string {
+
+	if s == "." || s == ".." {
+		return s
+	}
+
+	return strings.TrimRight(s, ". ")
+}
+
+// prefixes device names reserved by Windows, also with extensions
+func prefixReserved(s string) string {
+
+	base := strings.ToUpper(strings.SplitN(s, ".", 2)[0])
+	base = strings.TrimRight(base, " ")
+
+	switch base {
+	case "CON", "PRN", "AUX", "NUL":
+		return "_" + s
+	}
+	if len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) &&
+		base[3] >= '1' && base[3] <= '9' {
+		return "_" + s
+	}
+
+	return s
+}
+
+// cuts the name at a rune boundary, extensions up to 16 bytes are kept
+func (p PathPolicy) shorten(s string) string {
+
+	maxName := p.maxName()
+	if len(s) <= maxName {
+		return s
+	}
+
+	ext := filepath.Ext(s)
+	if len(ext) > 16 || len(ext) >= maxName {
+		ext = ""
+	}
+
+	base := s[:maxName-len(ext)]
+	for len(base) > 0 && !utf8.ValidString(base) {
+		base = base[:len(base)-1]
+	}
+
+	return base + ext
+}
+
+// sanitized paths of the files of a torrent, made unique among each other
+type PathSet struct {
+	policy PathPolicy
+	files  map[string]bool // by the key of the path
+	dirs   map[string]bool
+}
+
+func (p PathPolicy) NewPathSet() *PathSet {
+
+	return &PathSet{policy: p, files: make(map[string]bool), dirs: make(map[string]bool)}
+}
+
+// paths differing only by case are the same file on Windows and macOS
+func (s *PathSet) key(parts []string) string {
+
+	key := strings.Join(parts, "/")
+	if !s.policy.Unix {
+		key = strings.ToLower(key)
+	}
+
+	return key
+}
+
+// Add sanitizes the path and makes it unique among the paths added before
+//
+// A path of a file or a directory added before gets a " (2)" or higher
+// suffix before the extension of its last part, a path below a file added
+// before is rejected. Sanitizing alone can make distinct paths collide,
+// e.g. "a/b" and "a\b" or names cut to the same length.
+func (s *PathSet) Add(parts []string) PathResult {
+
+	res := s.policy.Sanitize(parts)
+	if res.Status == PathRejected {
+		return res
+	}
+
+	for j := 1; j < len(res.Parts); j++ {
+		if s.files[s.key(res.Parts[:j])] {
+			return rejectPath(res, fmt.Sprintf("below the file %q", filepath.Join(res.Parts[:j]...)))
+		}
+	}
+
+	last := len(res.Parts) - 1
+	name := res.Parts[last]
+	for n := 2; s.files[s.key(res.Parts)] || s.dirs[s.key(res.Parts)]; n++ {
+		res.Parts[last] = s.policy.addSuffix(name, fmt.Sprintf(" (%d)", n))
+	}
+	if res.Parts[last] != name {
+		res.Changes = append(res.Changes, PathChange{Part: res.last, Reason: "duplicate path",
+			From: name, To: res.Parts[last]})
+		res.Status = PathFixed
+		res.Path = filepath.Join(res.Parts...)
+		if len(res.Path) > s.policy.maxPath() {
+			return rejectPath(res, fmt.Sprintf("path of %d bytes", len(res.Path)))
+		}
+	}
+
+	s.files[s.key(res.Parts)] = true
+	for j := 1; j < len(res.Parts); j++ {
+		s.dirs[s.key(res.Parts[:j])] = true
+	}
+
+	return res
+}
+
+// inserts the suffix before the extension, shortening the name to fit
+func (p PathPolicy) addSuffix(name, suffix string) string {
+
+	ext := filepath.Ext(name)
+	if len(ext) > 16 || ext == name {
+		ext = ""
+	}
+	base := name[:len(name)-len(ext)]
+
+	if excess := len(base) + len(suffix) + len(ext) - p.maxName(); excess > 0 {
+		if excess > len(base) {
+			excess = len(base)
+		}
+		base = base[:len(base)-excess]
+		for len(base) > 0 && !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+	}
+
+	return base + suffix + ext
+}
+
+// applies the policy to all files of the torrent, fixed paths are replaced
+// and reported as warnings, rejected ones fail with ErrFileName
+//
+// Padding files aren't written to disk and may share their paths.
+func (p PathPolicy) sanitizeFiles(i *Info) error {
+
+	var ws warnings = i.Warnings
+	_, multiFile := i.keys["files"]
+	set := p.NewPathSet()
+
+	for j := range i.Files {
+		var res PathResult
+		if i.Files[j].IsPadding() {
+			res = p.Sanitize(i.paths[j])
+		} else {
+			res = set.Add(i.paths[j])
+		}
+		field := pathField(i, j, multiFile)
+
+		switch res.Status {
+		case PathRejected:
+			return newError(ErrFileName, field,
+				fmt.Sprintf("%q: %s", strings.Join(i.paths[j], "/"), res.Reason))
+		case PathFixed:
+			reasons := make([]string, len(res.Changes))
+			for k, change := range res.Changes {
+				reasons[k] = change.Reason
+			}
+			ws.add(WarnUnsafePath, field, -1, fmt.Sprintf("%s: %q",
+				strings.Join(reasons, ", "), res.Path))
+		}
+
+		i.Files[j].Path = res.Path
+	}
+
+	ws.locate(i.keys)
+	i.Warnings = ws
+
+	return nil
+}
+
+// name of the field holding the path of the file j
+func pathField(i *Info, j int, multiFile bool) string {
+
+	if multiFile && i.HasV1() {
+		return fmt.Sprintf("files/%d/path", j)
+	}
+	if len(i.paths[j]) > 1 {
+		return "file tree"
+	}
+
+	return "name"
+}
@@ -0,0 +1,112 @@
+package torrentparse
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+
+	long := strings.Repeat("x", 300)
+
+	tests := []struct {
+		policy  PathPolicy
+		parts   []string
+		status  PathStatus
+		path    string
+		reasons []string
+	}{
+		{PathPolicy{}, []string{"name", "dir", "file.txt"}, PathSafe, "name/dir/file.txt", nil},
+		{PathPolicy{}, []string{"naïve", "ファイル"}, PathSafe, "naïve/ファイル", nil},
+
+		{PathPolicy{}, []string{"/abs", "f"}, PathFixed, "abs/f", []string{"absolute path"}},
+		{PathPolicy{}, []string{`C:\win`, "f"}, PathFixed, "win/f", []string{"absolute path"}},
+		{PathPolicy{}, []string{"n", "bad\xffutf8"}, PathFixed, "n/bad\uFFFDutf8", []string{"invalid UTF-8"}},
+		{PathPolicy{}, []string{"n", `a\b`}, PathFixed, "n/a_b", []string{"path separator"}},
+		{PathPolicy{}, []string{"n", "a/b"}, PathFixed, "n/a_b", []string{"path separator"}},
+		{PathPolicy{}, []string{"n", "tab\tx"}, PathFixed, "n/tab_x", []string{"control character"}},
+		{PathPolicy{}, []string{"n", "what?"}, PathFixed, "n/what_", []string{"reserved character"}},
+		{PathPolicy{}, []string{"n", "dots.. "}, PathFixed, "n/dots", []string{"trailing dot or space"}},
+		{PathPolicy{}, []string{"n", "con.txt"}, PathFixed, "n/_con.txt", []string{"reserved name"}},
+		{PathPolicy{}, []string{"n", "LPT1"}, PathFixed, "n/_LPT1", []string{"reserved name"}},
+		{PathPolicy{}, []string{"n", "", ".", "f"}, PathFixed, "n/f",
+			[]string{"empty component", "empty component"}},
+		{PathPolicy{}, []string{"n", long + ".mkv"}, PathFixed, "n/" + long[:251] + ".mkv",
+			[]string{"name too long"}},
+		{PathPolicy{Replacement: "-"}, []string{"n", "a:b"}, PathFixed, "n/a-b",
+			[]string{"reserved character"}},
+
+		// Windows rules don't apply to Unix
+		{PathPolicy{Unix: true}, []string{"n", "con.txt"}, PathSafe, "n/con.txt", nil},
+		{PathPolicy{Unix: true}, []string{"n", "what? "}, PathSafe, "n/what? ", nil},
+
+		{PathPolicy{}, []string{"n", "..", "f"}, PathRejected, "", nil},
+		{PathPolicy{}, []string{"n", " .. "}, PathRejected, "", nil},
+		{PathPolicy{}, []string{"n", "nul\x00"}, PathRejected, "", nil},
+		{PathPolicy{}, []string{"", "."}, PathRejected, "", []string{"empty component", "empty component"}},
+		{PathPolicy{MaxPathLength: 10}, []string{"name", "file.txt"}, PathRejected, "", nil},
+	}
+
+	for _, test := range tests {
+		res := test.policy.Sanitize(test.parts)
+		var reasons []string
+		for _, change := range res.Changes {
+			reasons = append(reasons, change.Reason)
+		}
+
+		if res.Status != test.status || res.Path != filepath.FromSlash(test.path) ||
+			strings.Join(reasons, ",") != strings.Join(test.reasons, ",") {
+			t.Errorf("%q: %v %q %q, want %v %q %q", test.parts,
+				res.Status, res.Path, reasons, test.status, test.path, test.reasons)
+		}
+		if res.Status == PathRejected && res.Reason == "" {
+			t.Errorf("%q: rejected without reason", test.parts)
+		}
+	}
+}
+
+// paths sanitized to the same file are made unique or rejected
+func TestPathSet(t *testing.T) {
+
+	long := strings.Repeat("y", 260)
+
+	tests := []struct {
+		policy PathPolicy
+		paths  [][]string
+		want   []string // "" for rejected
+	}{
+		{PathPolicy{}, [][]string{{"n", "a/b"}, {"n", `a\b`}, {"n", "a_b"}},
+			[]string{"n/a_b", "n/a_b (2)", "n/a_b (3)"}},
+		{PathPolicy{}, [][]string{{"n", "File.txt"}, {"n", "file.TXT"}},
+			[]string{"n/File.txt", "n/file (2).TXT"}},
+		{PathPolicy{Unix: true}, [][]string{{"n", "File.txt"}, {"n", "file.TXT"}},
+			[]string{"n/File.txt", "n/file.TXT"}},
+		{PathPolicy{}, [][]string{{"n", "_con"}, {"n", "con"}},
+			[]string{"n/_con", "n/_con (2)"}},
+		{PathPolicy{}, [][]string{{"n", long + "1.txt"}, {"n", long + "2.txt"}},
+			[]string{"n/" + long[:251] + ".txt", "n/" + long[:247] + " (2).txt"}},
+
+		// a file and a directory of the same path
+		{PathPolicy{}, [][]string{{"n", "d", "f"}, {"n", "d"}},
+			[]string{"n/d/f", "n/d (2)"}},
+		{PathPolicy{}, [][]string{{"n", "d"}, {"n", "D", "f"}},
+			[]string{"n/d", ""}},
+	}
+
+	for _, test := range tests {
+		set := test.policy.NewPathSet()
+		for j, parts := range test.paths {
+			res := set.Add(parts)
+			want := filepath.FromSlash(test.want[j])
+			switch {
+			case want == "" && res.Status != PathRejected:
+				t.Errorf("%q: %v %q, want rejected", parts, res.Status, res.Path)
+			case want != "" && res.Path != want:
+				t.Errorf("%q: %v %q, want %q", parts, res.Status, res.Path, want)
+			case want != "" && res.Path != filepath.Join(parts...) && res.Status != PathFixed:
+				t.Errorf("%q: changed to %q but %v", parts, res.Path, res.Status)
+			}
+		}
+	}
+}
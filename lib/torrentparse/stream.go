@@ -0,0 +1,252 @@
+package torrentparse
+
+import (
+	"bytes"
+	"crypto/sha1" // nolint: gosec
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+)
+
+// reads the info dict hashing it on the fly, Bytes and the piece hashes
+// are kept unless DiscardRaw is set, offsets of errors are from the start
+// of the file
+func (o ParseOptions) streamInfo(s *bencode.Reader) (*Info, error) {
+
+	base := s.Offset()
+
+	hashV1 := sha1.New() // nolint: gosec
+	hashV2 := sha256.New()
+	var raw bytes.Buffer
+
+	s.Tee = io.MultiWriter(hashV1, hashV2)
+	if !o.DiscardRaw {
+		s.Tee = io.MultiWriter(hashV1, hashV2, &raw)
+	}
+	defer func() {
+		s.Tee = nil
+	}()
+
+	// issues of the info dict are reported with its warnings
+	mark := len(s.Issues)
+	var unknown warnings
+
+	var ib infoDict
+	keys := make(map[string]int64)
+
+	err := s.ReadDict(func(key string) error {
+		offset := s.Offset()
+		keys[key] = offset - base
+		if !knownInfoKeys[key] {
+			unknown.add(WarnUnknownKey, key, offset, "")
+		}
+
+		// keys are matched like when decoding the whole dict
+		switch strings.ToLower(key) {
+		case "pieces":
+			return o.streamPieces(s, &ib)
+		case "files":
+			return o.streamFiles(s, &ib, &unknown)
+		case "file tree":
+			return o.streamFileTree(s, &ib)
+		}
+
+		value, err := s.ReadValue()
+		if err != nil {
+			return err
+		}
+
+		return decodeInfoValue(&ib, key, value, offset)
+	})
+	issues := append([]bencode.Issue(nil), s.Issues[mark:]...)
+	s.Issues = s.Issues[:mark]
+	if err != nil {
+		return nil, locateKey(bencodeError("", 0, err), keys, base)
+	}
+
+	i, err := buildInfo(&ib)
+	if err != nil {
+		return nil, locateKey(err, keys, base)
+	}
+
+	i.keys = keys
+	if !o.DiscardRaw {
+		i.Bytes = raw.Bytes()
+	}
+
+	var sumV2 [32]byte
+	copy(sumV2[:], hashV2.Sum(nil))
+	setHash(i, hashV1.Sum(nil), sumV2)
+
+	var ws warnings
+	for _, is := range issues {
+		// paths start with the info key
+		field := ""
+		if parts := strings.SplitN(is.Path, "/", 2); len(parts) == 2 {
+			field = parts[1]
+		}
+		ws.add(is.Kind, field, is.Offset-base, is.Detail)
+	}
+	for _, w := range unknown {
+		ws.add(w.Kind, w.Field, w.Offset-base, w.Detail)
+	}
+	contentWarnings(&ws, i, &ib)
+	i.Warnings = ws
+
+	if err := o.finishInfo(i); err != nil {
+		return nil, locateKey(err, keys, base)
+	}
+
+	return i, nil
+}
+
+// the piece hashes are kept or only counted
+func (o ParseOptions) streamPieces(s *bencode.Reader, ib *infoDict) error {
+
+	offset := s.Offset()
+
+	c, err := s.Peek()
+	if err != nil {
+		return err
+	}
+
+	if c < '0' || c > '9' {
+		value, err := s.ReadValue()
+		if err != nil {
+			return err
+		}
+		return decodeInfoValue(ib, "pieces", value, offset)
+	}
+
+	if o.DiscardRaw {
+		n, err := s.ReadString(io.Discard)
+		ib.Pieces = nil
+		ib.piecesLen = int(n)
+		return err
+	}
+
+	var pieces bytes.Buffer
+	if _, err := s.ReadString(&pieces); err != nil {
+		return err
+	}
+	ib.Pieces = pieces.Bytes()
+	ib.piecesLen = len(ib.Pieces)
+
+	return nil
+}
+
+// files are decoded one by one, limits are checked before reading them all
+func (o ParseOptions) streamFiles(s *bencode.Reader, ib *infoDict, unknown *warnings) error {
+
+	offset := s.Offset()
+
+	c, err := s.Peek()
+	if err != nil {
+		return err
+	}
+
+	if c != 'l' {
+		value, err := s.ReadValue()
+		if err != nil {
+			return err
+		}
+		return decodeInfoValue(ib, "files", value, offset)
+	}
+
+	ib.Files = nil
+
+	return s.ReadList(func(j int) error {
+		if o.MaxFiles > 0 && j >= o.MaxFiles {
+			return o.filesLimitError("files")
+		}
+
+		field := fmt.Sprintf("files/%d", j)
+		fileOffset := s.Offset()
+
+		value, err := s.ReadValue()
+		if err != nil {
+			return err
+		}
+		v, err := bencode.Parse(value)
+		if err != nil {
+			return bencodeError(field, fileOffset, err)
+		}
+		unknownKeys(unknown, v, fileOffset, field+"/", knownFileKeys)
+
+		var f file
+		if err := v.Decode(&f); err != nil {
+			return bencodeError(field, fileOffset, err)
+		}
+
+		if o.MaxPathDepth > 0 && len(f.Path) > o.MaxPathDepth {
+			e := newError(ErrLimit, field+"/path",
+				fmt.Sprintf("path depth %d, limit %d", len(f.Path), o.MaxPathDepth))
+			e.Offset = fileOffset
+			return e
+		}
+
+		ib.Files = append(ib.Files, f)
+
+		return nil
+	})
+}
+
+// the tree is walked node by node, limits are checked while reading it
+func (o ParseOptions) streamFileTree(s *bencode.Reader, ib *infoDict) error {
+
+	t := treeReader{s: s, o: o}
+
+	tree, err := t.node("file tree", 0)
+	if err != nil {
+		return err
+	}
+	ib.FileTree = nil
+	ib.tree, ib.treeErr = tree, t.err
+
+	return nil
+}
+
+// decodes the value of a key of the info dict into ib
+func decodeInfoValue(ib *infoDict, key string, value bencode.RawMessage, offset int64) error {
+
+	var v interface{}
+
+	switch strings.ToLower(key) {
+	case "piece length":
+		v = &ib.PieceLength
+	case "pieces":
+		v = &ib.Pieces
+	case "name":
+		v = &ib.Name
+	case "length":
+		v = &ib.Length
+	case "files":
+		v = &ib.Files
+	case "meta version":
+		v = &ib.MetaVersion
+	case "file tree":
+		ib.FileTree, ib.tree, ib.treeErr = value, nil, nil
+	case "private":
+		ib.Private = value
+	case "source":
+		ib.Source = value
+	case "attr":
+		ib.Attr = value
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	if err := bencode.Unmarshal(value, v); err != nil {
+		return bencodeError(key, offset, err)
+	}
+	if v == &ib.Pieces {
+		ib.piecesLen = len(ib.Pieces)
+	}
+
+	return nil
+}
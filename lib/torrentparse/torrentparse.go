@@ -2,132 +2,227 @@ package torrentparse
 
 import (
 	"crypto/sha1" // nolint: gosec
+	"crypto/sha256"
 	"fmt"
-	"io"
 	"log"
 	"path/filepath"
 	"strings"
 	"unicode"
 
-	"github.com/zeebo/bencode"
+	"github.com/torrentdb/torrent_utils/lib/bencode"
 )
 
 // information about torrent from metainfo dictionary
+//
+// Hash is the SHA-1 infohash for v1 and hybrid torrents and the truncated
+// SHA-256 infohash for v2-only torrents, HashV2 is set for v2 and hybrid
 type Info struct {
 	PieceLength  uint32
 	Name         string
-	Hash         [20]byte
+	Hash         InfoHash
+	HashV2       InfoHashV2
 	HashInt      uint64
 	HashStr      string
 	HashStrShort string
 	Length       int64
 	NumPieces    uint32
+	MetaVersion  int
+	Private      bool
+	Source       string
 	Bytes        []byte
 	Files        []File
 	FilesNo      int
+	FileTree     *FileTree
+	PieceLayers  map[[32]byte][]byte
+	Warnings     []Warning // defects tolerated in lenient mode
 	pieces       []byte
+	hybrid       bool
+	paths        [][]string       // name and path of every file as in the torrent
+	keys         map[string]int64 // offsets of the values of the info keys
 }
 
-// files inside a torrent
+// files inside a torrent, PiecesRoot is set only for v2 files
+//
+// Attr holds the BEP 47 attributes, e.g. "px", SymlinkPath is the target
+// of symlinks relative to the torrent root
 type File struct {
-	Length int64
-	Path   string
+	Length      int64
+	Path        string
+	PiecesRoot  [32]byte
+	Attr        string
+	SymlinkPath string
 }
 
 type file struct {
-	Length int64    `bencode:"length"`
-	Path   []string `bencode:"path"`
+	Length      int64              `bencode:"length"`
+	Path        []string           `bencode:"path"`
+	Attr        bencode.RawMessage `bencode:"attr,omitempty"`         // BEP 47
+	SymlinkPath bencode.RawMessage `bencode:"symlink path,omitempty"` // BEP 47
 }
 
-func ParseTorrent(r io.Reader) (*Info, error) {
+// the info dictionary as decoded, Pieces is nil when the piece hashes are
+// not retained by the streaming decoder
+type infoDict struct {
+	PieceLength uint32             `bencode:"piece length"`
+	Pieces      []byte             `bencode:"pieces"`
+	Name        string             `bencode:"name"`
+	Length      int64              `bencode:"length"`       // Single File Mode
+	Files       []file             `bencode:"files"`        // Multiple File mode
+	MetaVersion int                `bencode:"meta version"` // BEP 52
+	FileTree    bencode.RawMessage `bencode:"file tree"`    // BEP 52
+	Private     bencode.RawMessage `bencode:"private"`      // BEP 27
+	Source      bencode.RawMessage `bencode:"source"`
+	Attr        bencode.RawMessage `bencode:"attr"` // BEP 47
+	piecesLen   int
+	tree        *FileTree // file tree walked by the streaming decoder
+	treeErr     error     // its first invalid node
+}
 
-	var metaInfo struct {
-		Info bencode.RawMessage `bencode:"info"`
-	}
+// parses the info dictionary b decoded into v
+func parseInfo(b []byte, v *bencode.Value) (*Info, error) {
 
-	err := bencode.NewDecoder(r).Decode(&metaInfo)
-	if err != nil {
-		return nil, fmt.Errorf("Error when decoding metainfo dictionary.")
-	}
+	var ib infoDict
 
-	if len(metaInfo.Info) == 0 {
-		return nil, fmt.Errorf("No info dict in torrent file.")
+	if err := v.Decode(&ib); err != nil {
+		return nil, bencodeError("", 0, err)
 	}
+	ib.piecesLen = len(ib.Pieces)
 
-	info, err := ParseInfo(metaInfo.Info)
+	i, err := buildInfo(&ib)
 	if err != nil {
 		return nil, err
 	}
 
-	return info, nil
+	i.Bytes = b
+	i.keys = v.Offsets()
+	calcHash(i)
+
+	i.Warnings = infoWarnings(b, v, i, &ib)
+
+	return i, nil
 }
 
-func ParseInfo(b []byte) (*Info, error) {
+func buildInfo(ib *infoDict) (*Info, error) {
 
-	var ib struct {
-		PieceLength uint32 `bencode:"piece length"`
-		Pieces      []byte `bencode:"pieces"`
-		Name        string `bencode:"name"`
-		Length      int64  `bencode:"length"` // Single File Mode
-		Files       []file `bencode:"files"`  // Multiple File mode
+	if ib.PieceLength == 0 {
+		return nil, newError(ErrPieceLength, "piece length", "zero piece length")
 	}
 
-	if err := bencode.DecodeBytes(b, &ib); err != nil {
-		return nil, fmt.Errorf("Error when decoding info dictionary.")
+	if ib.MetaVersion == 0 {
+		ib.MetaVersion = 1
+	}
+	if ib.MetaVersion != 1 && ib.MetaVersion != 2 {
+		return nil, newError(ErrMetaVersion, "meta version",
+			fmt.Sprint("version ", ib.MetaVersion))
 	}
 
-	if ib.PieceLength == 0 {
-		return nil, fmt.Errorf("Torrent has zero piece length.")
+	// v2 torrents without pieces are v2 only, with pieces they are hybrid
+	v2 := ib.MetaVersion == 2
+	v1 := !v2 || ib.piecesLen > 0
+
+	i := Info{
+		PieceLength: ib.PieceLength,
+		MetaVersion: ib.MetaVersion,
+		Name:        ib.Name,
+		Source:      rawString(ib.Source),
+		hybrid:      v1 && v2,
 	}
 
-	if len(ib.Pieces)%sha1.Size != 0 {
-		return nil, fmt.Errorf("Invalid piece data.")
+	if private, ok := rawInt(ib.Private); ok && private == 1 {
+		i.Private = true
 	}
 
-	numPieces := len(ib.Pieces) / sha1.Size
-	if numPieces == 0 {
-		return nil, fmt.Errorf("Torrent has zero pieces.")
+	if v1 {
+		if err := parseV1(&i, ib); err != nil {
+			return nil, err
+		}
+		if len(ib.Files) == 0 {
+			i.Files[0].Attr = rawString(ib.Attr)
+		}
 	}
 
-	if err := validateFilenames(ib.Files); err != nil {
-		return nil, err
+	if v2 {
+		tree, err := ib.fileTree()
+		if err != nil {
+			return nil, err
+		}
+		i.FileTree = tree
+		if err := parseV2(&i, tree, v1); err != nil {
+			return nil, err
+		}
 	}
+	i.FilesNo = len(i.Files)
 
-	i := Info{
-		PieceLength: ib.PieceLength,
-		NumPieces:   uint32(numPieces),
-		pieces:      ib.Pieces,
-		Name:        ib.Name,
+	if ib.Name == "" {
+		i.Name = "__empty_name_field_in_info_dict__"
+	} else {
+		i.Name = ib.Name
 	}
 
-	multiFile := len(ib.Files) > 0
+	return &i, nil
+}
+
+// the file tree as walked by the streaming decoder or parsed from its value
+func (ib *infoDict) fileTree() (*FileTree, error) {
+
+	if ib.tree != nil || ib.treeErr != nil {
+		return ib.tree, ib.treeErr
+	}
+	if len(ib.FileTree) == 0 {
+		return nil, newError(ErrFileTree, "file tree", "no file tree in v2 torrent")
+	}
+
+	return parseFileTree(ib.FileTree, "file tree")
+}
+
+func parseV1(i *Info, ib *infoDict) error {
+
+	if ib.piecesLen%sha1.Size != 0 {
+		return newError(ErrPieces, "pieces", "length not a multiple of 20")
+	}
+
+	files := ib.Files
+	numPieces := ib.piecesLen / sha1.Size
+	if numPieces == 0 {
+		return newError(ErrNoPieces, "pieces", "")
+	}
+
+	if err := validateFilenames(files); err != nil {
+		return err
+	}
+
+	i.NumPieces = uint32(numPieces)
+	i.pieces = ib.Pieces
+
+	multiFile := len(files) > 0
 	if multiFile {
-		for _, f := range ib.Files {
+		for j, f := range files {
+			if f.Length < 0 {
+				return newError(ErrLength, fmt.Sprintf("files/%d/length", j),
+					fmt.Sprint("negative file length ", f.Length))
+			}
 			i.Length += f.Length
 		}
-		parseMultiFiles(&i, ib.Files)
+		parseMultiFiles(i, files)
 	} else {
 		i.Length = ib.Length
 		i.Files = []File{{Path: cleanName(i.Name), Length: i.Length}}
+		i.paths = [][]string{{i.Name}}
 	}
-	i.FilesNo = len(i.Files)
 
 	totalPieceDataLength := int64(i.PieceLength) * int64(i.NumPieces)
 	delta := totalPieceDataLength - i.Length
 	if delta >= int64(i.PieceLength) || delta < 0 {
-		return nil, fmt.Errorf("Invalid piece length.")
-	}
-
-	i.Bytes = b
-	calcHash(&i)
-
-	if ib.Name == "" {
-		i.Name = "__empty_name_field_in_info_dict__"
-	} else {
-		i.Name = ib.Name
+		field := "length"
+		if multiFile {
+			field = "files"
+		}
+		return newError(ErrLength, field, fmt.Sprintf(
+			"%d pieces of %d bytes for %d bytes of data",
+			i.NumPieces, i.PieceLength, i.Length))
 	}
 
-	return &i, nil
+	return nil
 }
 
 func cleanName(s string) string {
@@ -146,14 +241,14 @@ func errExit(err error) {
 
 func validateFilenames(files []file) error {
 
-	for _, file := range files {
+	for j, file := range files {
 
 		// ".." is not allowed in file names
 		for _, pathPart := range file.Path {
 			pathPart = strings.TrimSpace(pathPart)
 			if pathPart == ".." {
-				return fmt.Errorf("invalid file name: %q",
-					filepath.Join(file.Path...))
+				return newError(ErrFileName, fmt.Sprintf("files/%d/path", j),
+					fmt.Sprintf("%q", filepath.Join(file.Path...)))
 			}
 		}
 	}
@@ -163,15 +258,20 @@ func validateFilenames(files []file) error {
 func parseMultiFiles(i *Info, files []file) {
 
 	i.Files = make([]File, len(files))
+	i.paths = make([][]string, len(files))
 	for j, f := range files {
+		i.paths[j] = append([]string{i.Name}, f.Path...)
+
 		parts := make([]string, 0, len(f.Path)+1)
 		parts = append(parts, cleanName(i.Name))
 		for _, p := range f.Path {
 			parts = append(parts, cleanName(p))
 		}
 		i.Files[j] = File{
-			Path:   filepath.Join(parts...),
-			Length: f.Length,
+			Path:        filepath.Join(parts...),
+			Length:      f.Length,
+			Attr:        fileAttr(f),
+			SymlinkPath: filepath.Join(rawStrings(f.SymlinkPath)...),
 		}
 	}
 }
@@ -180,5 +280,30 @@ func calcHash(i *Info) {
 
 	hash := sha1.New()         // nolint: gosec
 	_, _ = hash.Write(i.Bytes) // nolint: gosec
-	copy(i.Hash[:], hash.Sum(nil))
+
+	var sumV2 [32]byte
+	if i.HasV2() {
+		sumV2 = sha256.Sum256(i.Bytes)
+	}
+
+	setHash(i, hash.Sum(nil), sumV2)
+}
+
+// sets the infohashes from the SHA-1 and SHA-256 sums of the info dict
+func setHash(i *Info, sumV1 []byte, sumV2 [32]byte) {
+
+	copy(i.Hash[:], sumV1)
+
+	if i.HasV2() {
+		i.HashV2 = sumV2
+	}
+
+	// v2 only torrents are identified by the truncated v2 infohash
+	if !i.HasV1() {
+		i.Hash = i.HashV2.Truncated()
+	}
+
+	i.HashInt = i.Hash.Int()
+	i.HashStr = i.Hash.String()
+	i.HashStrShort = i.Hash.Short()
 }
@@ -0,0 +1,318 @@
+package torrentparse
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+)
+
+// BEP 52 block size, v2 piece length can't be smaller than that
+const blockSize = 16 * 1024
+
+// node of a v2 file tree, files have no children
+type FileTree struct {
+	Length      int64
+	PiecesRoot  [32]byte
+	Attr        string // BEP 47
+	SymlinkPath string
+	Children    map[string]*FileTree
+}
+
+type v2file struct {
+	Length      int64              `bencode:"length"`
+	PiecesRoot  []byte             `bencode:"pieces root"`
+	Attr        bencode.RawMessage `bencode:"attr"`
+	SymlinkPath bencode.RawMessage `bencode:"symlink path"`
+}
+
+// HasV1 reports if the torrent can be downloaded with v1 (SHA-1) pieces
+func (i *Info) HasV1() bool {
+
+	return i.MetaVersion != 2 || i.hybrid
+}
+
+// HasV2 reports if the torrent has a v2 file tree and a SHA-256 infohash
+func (i *Info) HasV2() bool {
+
+	return i.MetaVersion == 2
+}
+
+// IsHybrid reports if the torrent carries both v1 and v2 metadata
+func (i *Info) IsHybrid() bool {
+
+	return i.HasV1() && i.HasV2()
+}
+
+// path is the field name used in errors, e.g. "file tree/dir/file"
+func parseFileTree(raw bencode.RawMessage, path string) (*FileTree, error) {
+
+	t := treeReader{s: bencode.NewReader(bytes.NewReader(raw))}
+
+	node, err := t.node(path, 0)
+	if err != nil {
+		return nil, bencodeError(path, 0, err)
+	}
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	return node, nil
+}
+
+// walks a file tree read from s, the limits of o are checked while reading
+// it and the first invalid node is kept in err, as file trees of v1
+// torrents are ignored
+type treeReader struct {
+	s     *bencode.Reader
+	o     ParseOptions
+	files int
+	err   error
+}
+
+func (t *treeReader) invalid(path string, detail string, cause error) {
+
+	if t.err == nil {
+		e := newError(ErrFileTree, path, detail)
+		e.Cause = cause
+		t.err = e
+	}
+}
+
+// reads the node at the depth below the root, nil if it's invalid
+func (t *treeReader) node(path string, depth int) (*FileTree, error) {
+
+	c, err := t.s.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if c != 'd' {
+		if _, err := t.s.ReadValue(); err != nil {
+			return nil, err
+		}
+		t.invalid(path, "not a dictionary", nil)
+		return nil, nil
+	}
+
+	var leaf bencode.RawMessage
+	children := make(map[string]*FileTree)
+	names := make(map[string]bool)
+
+	err = t.s.ReadDict(func(name string) error {
+		names[name] = true
+		if name == "" {
+			var err error
+			leaf, err = t.s.ReadValue()
+			return err
+		}
+
+		if t.o.MaxPathDepth > 0 && depth+1 > t.o.MaxPathDepth {
+			e := newError(ErrLimit, path+"/"+name,
+				fmt.Sprintf("path depth %d, limit %d", depth+1, t.o.MaxPathDepth))
+			e.Offset = t.s.Offset()
+			return e
+		}
+
+		child, err := t.node(path+"/"+name, depth+1)
+		children[name] = child
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if leaf == nil {
+		if len(names) == 0 {
+			t.invalid(path, "empty directory", nil)
+			return nil, nil
+		}
+		return &FileTree{Children: children}, nil
+	}
+
+	if len(names) != 1 {
+		t.invalid(path, "both a file and a directory", nil)
+		return nil, nil
+	}
+
+	t.files++
+	if t.o.MaxFiles > 0 && t.files > t.o.MaxFiles {
+		e := t.o.filesLimitError("file tree")
+		e.Offset = t.s.Offset()
+		return nil, e
+	}
+
+	var f v2file
+	if err := bencode.Unmarshal(leaf, &f); err != nil {
+		t.invalid(path, "", err)
+		return nil, nil
+	}
+	if f.Length < 0 {
+		t.invalid(path, "negative file length", nil)
+		return nil, nil
+	}
+	if f.Length > 0 && len(f.PiecesRoot) != sha256.Size {
+		t.invalid(path, "invalid pieces root", nil)
+		return nil, nil
+	}
+
+	node := FileTree{Length: f.Length, Attr: rawString(f.Attr),
+		SymlinkPath: filepath.Join(rawStrings(f.SymlinkPath)...)}
+	copy(node.PiecesRoot[:], f.PiecesRoot)
+
+	return &node, nil
+}
+
+// flattens the tree into the files list and the matching leaves, in the
+// order of sorted keys
+func (t *FileTree) files(path []string, files []file, leaves []*FileTree) ([]file, []*FileTree) {
+
+	if t.Children == nil {
+		p := make([]string, len(path))
+		copy(p, path)
+		return append(files, file{Length: t.Length, Path: p}), append(leaves, t)
+	}
+
+	names := make([]string, 0, len(t.Children))
+	for name := range t.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		files, leaves = t.Children[name].files(append(path, name), files, leaves)
+	}
+
+	return files, leaves
+}
+
+func parseV2(i *Info, tree *FileTree, v1 bool) error {
+
+	if tree.Children == nil {
+		return newError(ErrFileTree, "file tree", "root is not a directory")
+	}
+
+	if i.PieceLength < blockSize || i.PieceLength&(i.PieceLength-1) != 0 {
+		return newError(ErrPieceLength, "piece length",
+			"v2 piece length must be a power of two of at least 16 KiB")
+	}
+
+	files, leaves := tree.files(nil, nil, nil)
+	if err := validateFilenames(files); err != nil {
+		return err
+	}
+
+	singleFile := len(files) == 1 && len(files[0].Path) == 1 &&
+		files[0].Path[0] == i.Name
+
+	if v1 {
+		return matchV1Files(i, files, leaves, singleFile)
+	}
+
+	var numPieces int64
+	for _, f := range files {
+		i.Length += f.Length
+		numPieces += (f.Length + int64(i.PieceLength) - 1) / int64(i.PieceLength)
+	}
+	if numPieces == 0 {
+		return newError(ErrNoPieces, "file tree", "")
+	}
+	i.NumPieces = uint32(numPieces)
+
+	if singleFile {
+		i.Files = []File{{Path: cleanName(i.Name), Length: files[0].Length}}
+		i.paths = [][]string{{i.Name}}
+	} else {
+		parseMultiFiles(i, files)
+	}
+	for j := range i.Files {
+		i.Files[j].PiecesRoot = leaves[j].PiecesRoot
+		i.Files[j].Attr = leaves[j].Attr
+		i.Files[j].SymlinkPath = leaves[j].SymlinkPath
+	}
+
+	return nil
+}
+
+// in hybrid torrents every file of the v2 tree must be in the v1 files list
+func matchV1Files(i *Info, files []file, leaves []*FileTree, singleFile bool) error {
+
+	if singleFile {
+		if len(i.Files) != 1 || i.Files[0].Length != files[0].Length {
+			return newError(ErrFileTree, "file tree", "hybrid file tree doesn't match the file")
+		}
+		i.Files[0].PiecesRoot = leaves[0].PiecesRoot
+		return nil
+	}
+
+	v2Files := Info{Name: i.Name}
+	parseMultiFiles(&v2Files, files)
+
+	index := make(map[string]int, len(i.Files))
+	for j, f := range i.Files {
+		index[f.Path] = j
+	}
+
+	for j, f := range v2Files.Files {
+		k, exists := index[f.Path]
+		if !exists || i.Files[k].Length != f.Length {
+			return newError(ErrFileTree, "file tree",
+				fmt.Sprintf("hybrid file tree doesn't match files list: %q", f.Path))
+		}
+		i.Files[k].PiecesRoot = leaves[j].PiecesRoot
+	}
+
+	return nil
+}
+
+// attaches piece layers from the metainfo dictionary to the parsed info
+func setPieceLayers(i *Info, layers map[string][]byte) error {
+
+	if len(layers) == 0 {
+		return nil
+	}
+
+	lengths := make(map[string]int, len(layers))
+	for root, layer := range layers {
+		lengths[root] = len(layer)
+	}
+	if err := checkPieceLayers(i, lengths); err != nil {
+		return err
+	}
+
+	i.PieceLayers = make(map[[32]byte][]byte, len(layers))
+	for root, layer := range layers {
+		var key [32]byte
+		copy(key[:], root)
+		i.PieceLayers[key] = layer
+	}
+
+	return nil
+}
+
+// checks the lengths of the piece layers by pieces root against the files,
+// -1 for layers that aren't strings
+func checkPieceLayers(i *Info, lengths map[string]int) error {
+
+	for root, n := range lengths {
+		if len(root) != sha256.Size || n < 0 || n%sha256.Size != 0 {
+			return newError(ErrPieceLayers, "piece layers", "invalid layer")
+		}
+	}
+
+	for _, f := range i.Files {
+		n, exists := lengths[string(f.PiecesRoot[:])]
+		if !exists || f.Length <= int64(i.PieceLength) {
+			continue
+		}
+		numPieces := (f.Length + int64(i.PieceLength) - 1) / int64(i.PieceLength)
+		if int64(n) != numPieces*sha256.Size {
+			return newError(ErrPieceLayers, "piece layers",
+				fmt.Sprintf("invalid layer length for file: %q", f.Path))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package torrentparse
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+)
+
+// v2 torrent of the files by path, with the piece layers of the files
+// longer than a piece
+func v2Torrent(t *testing.T, files map[string]int64) []byte {
+
+	tree := make(map[string]interface{})
+	layers := make(map[string]interface{})
+
+	roots := 0
+	for path, length := range files {
+		node := tree
+		parts := strings.Split(path, "/")
+		for _, part := range parts[:len(parts)-1] {
+			child, exists := node[part].(map[string]interface{})
+			if !exists {
+				child = make(map[string]interface{})
+				node[part] = child
+			}
+			node = child
+		}
+
+		root := strings.Repeat(string(rune('a'+roots)), 32)
+		roots++
+		node[parts[len(parts)-1]] = map[string]interface{}{
+			"": map[string]interface{}{"length": length, "pieces root": root},
+		}
+		if length > 16384 {
+			layers[root] = strings.Repeat("h", int((length+16383)/16384)*32)
+		}
+	}
+
+	b, err := bencode.Marshal(map[string]interface{}{
+		"info": map[string]interface{}{
+			"file tree":    tree,
+			"meta version": 2,
+			"name":         "t",
+			"piece length": 16384,
+		},
+		"piece layers": layers,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// piece layers are kept unless DiscardRaw is set, the file tree is read
+// the same way by the streaming decoder and by ParseInfo
+func TestParseV2(t *testing.T) {
+
+	b := v2Torrent(t, map[string]int64{"d/a": 40000, "d/b": 5, "c": 16384})
+
+	for _, discard := range []bool{false, true} {
+		m, err := ParseOptions{DiscardRaw: discard}.ParseMetaInfo(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("discard %v: %v", discard, err)
+		}
+		i := m.Info
+
+		if !i.HasV2() || i.HasV1() || i.FilesNo != 3 || i.Length != 56389 || i.NumPieces != 5 {
+			t.Errorf("discard %v: v2 %v, %d files, %d bytes, %d pieces",
+				discard, i.HasV2(), i.FilesNo, i.Length, i.NumPieces)
+		}
+		if i.Files[0].Path != "t/c" || i.Files[1].Path != "t/d/a" {
+			t.Errorf("discard %v: files %q, %q", discard, i.Files[0].Path, i.Files[1].Path)
+		}
+		if discard != (i.PieceLayers == nil) || discard != (i.PieceHash(1) == nil) {
+			t.Errorf("discard %v: %d piece layers", discard, len(i.PieceLayers))
+		}
+
+		if discard {
+			continue
+		}
+		again, err := ParseInfo(i.Bytes)
+		if err != nil || again.HashV2 != i.HashV2 || again.FilesNo != i.FilesNo {
+			t.Errorf("ParseInfo: %v", err)
+		}
+	}
+
+	// layers are checked against the files whether they are kept or not
+	bad := bytes.Replace(b, []byte("96:"+strings.Repeat("h", 96)), []byte("64:"+strings.Repeat("h", 64)), 1)
+	for _, discard := range []bool{false, true} {
+		_, err := ParseOptions{DiscardRaw: discard}.ParseMetaInfo(bytes.NewReader(bad))
+		if !errors.Is(err, ErrPieceLayers) {
+			t.Errorf("discard %v: short layer: %v", discard, err)
+		}
+	}
+}
+
+// the limits stop reading the file tree, the rest of the data isn't read
+func TestFileTreeLimits(t *testing.T) {
+
+	b := v2Torrent(t, map[string]int64{"d/f0": 1, "d/f1": 1, "d/f2": 1, "d/f3": 1, "d/f4": 1})
+	truncated := b[:bytes.Index(b, []byte("2:f4"))]
+
+	for _, data := range [][]byte{b, truncated} {
+		_, err := ParseOptions{MaxFiles: 3}.ParseMetaInfo(bytes.NewReader(data))
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Err != ErrLimit || pe.Field != "file tree" {
+			t.Errorf("%d bytes, 5 files, limit 3: %v", len(data), err)
+		}
+	}
+	limits := ParseOptions{MaxFiles: 5}
+	if _, err := limits.ParseMetaInfo(bytes.NewReader(b)); err != nil {
+		t.Errorf("5 files, limit 5: %v", err)
+	}
+	if _, err := ParseMetaInfo(bytes.NewReader(truncated)); !errors.Is(err, ErrDecode) {
+		t.Errorf("truncated: %v", err)
+	}
+
+	b = v2Torrent(t, map[string]int64{"a/b/c/f": 1})
+	truncated = b[:bytes.Index(b, []byte("1:f"))+3]
+
+	for _, data := range [][]byte{b, truncated} {
+		_, err := ParseOptions{MaxPathDepth: 3}.ParseMetaInfo(bytes.NewReader(data))
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Err != ErrLimit || pe.Field != "file tree/a/b/c/f" {
+			t.Errorf("%d bytes, depth 4, limit 3: %v", len(data), err)
+		}
+	}
+	limits = ParseOptions{MaxPathDepth: 4}
+	if _, err := limits.ParseMetaInfo(bytes.NewReader(b)); err != nil {
+		t.Errorf("depth 4, limit 4: %v", err)
+	}
+}
+
+// the file tree of a v1 torrent is ignored, even when it's invalid
+func TestV1FileTree(t *testing.T) {
+
+	b, err := bencode.Marshal(map[string]interface{}{
+		"info": map[string]interface{}{
+			"file tree":    "x",
+			"length":       1,
+			"name":         "t",
+			"piece length": 16384,
+			"pieces":       strings.Repeat("p", 20),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseTorrent(bytes.NewReader(b)); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseInfo(infoBytes(t, b)); err != nil {
+		t.Errorf("ParseInfo: %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+package torrentparse
+
+import (
+	"bytes"
+	"crypto/sha1" // nolint: gosec
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// part of a piece stored in a file
+type FileSpan struct {
+	File   int   // index in Info.Files
+	Offset int64 // offset in the file
+	Length int64
+}
+
+// result of checking local data against the torrent pieces
+type VerifyResult struct {
+	Pieces    int
+	BadPieces []int
+	Files     []FileStatus
+}
+
+// state of a single file after verification, Size is -1 for missing files
+type FileStatus struct {
+	Path       string
+	Length     int64
+	Size       int64
+	Pieces     int
+	GoodPieces int
+	Complete   bool
+}
+
+// Complete reports if all pieces and files were verified
+func (r *VerifyResult) Complete() bool {
+
+	if len(r.BadPieces) > 0 {
+		return false
+	}
+	for _, fs := range r.Files {
+		if !fs.Complete {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PieceHash returns the SHA-1 hash of the v1 piece n, or the SHA-256 hash
+// from the piece layers for v2-only torrents, nil if it's not available
+func (i *Info) PieceHash(n int) []byte {
+
+	if n < 0 || n >= int(i.NumPieces) {
+		return nil
+	}
+
+	if i.HasV1() {
+		if len(i.pieces) < (n+1)*sha1.Size {
+			return nil
+		}
+		return i.pieces[n*sha1.Size : (n+1)*sha1.Size]
+	}
+
+	for _, span := range i.PieceSpans(n) {
+		f := i.Files[span.File]
+		if f.Length <= int64(i.PieceLength) {
+			return f.PiecesRoot[:]
+		}
+		layer, exists := i.PieceLayers[f.PiecesRoot]
+		if !exists {
+			return nil
+		}
+		p := int(span.Offset / int64(i.PieceLength))
+		return layer[p*sha256.Size : (p+1)*sha256.Size]
+	}
+
+	return nil
+}
+
+// PieceSpans returns file ranges making up the piece n, v1 pieces span the
+// files concatenated in the order of Files, v2 pieces are aligned to files
+func (i *Info) PieceSpans(n int) []FileSpan {
+
+	if n < 0 || n >= int(i.NumPieces) {
+		return nil
+	}
+
+	pieceLength := int64(i.PieceLength)
+
+	if !i.HasV1() {
+		for j, f := range i.Files {
+			filePieces := int((f.Length + pieceLength - 1) / pieceLength)
+			if n >= filePieces {
+				n -= filePieces
+				continue
+			}
+			offset := int64(n) * pieceLength
+			length := pieceLength
+			if offset+length > f.Length {
+				length = f.Length - offset
+			}
+			return []FileSpan{{File: j, Offset: offset, Length: length}}
+		}
+		return nil
+	}
+
+	var spans []FileSpan
+	start := int64(n) * pieceLength
+	end := start + pieceLength
+
+	var fileStart int64
+	for j, f := range i.Files {
+		fileEnd := fileStart + f.Length
+		if fileEnd > start && fileStart < end {
+			spanStart := start
+			if fileStart > spanStart {
+				spanStart = fileStart
+			}
+			spanEnd := end
+			if fileEnd < spanEnd {
+				spanEnd = fileEnd
+			}
+			spans = append(spans, FileSpan{
+				File:   j,
+				Offset: spanStart - fileStart,
+				Length: spanEnd - spanStart,
+			})
+		}
+		if fileEnd >= end {
+			break
+		}
+		fileStart = fileEnd
+	}
+
+	return spans
+}
+
+// Verify hashes the data in rootDir piece by piece, files are expected at
+// rootDir joined with File.Path
+func (i *Info) Verify(rootDir string) (*VerifyResult, error) {
+
+	if !i.HasV1() && len(i.PieceLayers) == 0 && hasLargeFiles(i) {
+		return nil, fmt.Errorf("no piece layers to verify v2 torrent")
+	}
+
+	if i.HasV1() && len(i.pieces) == 0 {
+		return nil, fmt.Errorf("piece hashes were not retained")
+	}
+
+	v := verifier{info: i, rootDir: rootDir, files: make(map[int]*os.File)}
+	defer v.close()
+
+	res := VerifyResult{Pieces: int(i.NumPieces)}
+	res.Files = make([]FileStatus, len(i.Files))
+	for j, f := range i.Files {
+		res.Files[j] = FileStatus{Path: f.Path, Length: f.Length, Size: v.size(j)}
+	}
+
+	for n := 0; n < int(i.NumPieces); n++ {
+		spans := i.PieceSpans(n)
+
+		ok := v.checkPiece(n, spans)
+		if !ok {
+			res.BadPieces = append(res.BadPieces, n)
+		}
+
+		for _, span := range spans {
+			res.Files[span.File].Pieces++
+			if ok {
+				res.Files[span.File].GoodPieces++
+			}
+		}
+
+		// later pieces start in the last file of this one
+		if len(spans) > 0 {
+			v.release(spans[len(spans)-1].File)
+		}
+	}
+
+	for j := range res.Files {
+		fs := &res.Files[j]
+		fs.Complete = fs.Size == fs.Length && fs.GoodPieces == fs.Pieces
+	}
+
+	return &res, nil
+}
+
+func hasLargeFiles(i *Info) bool {
+
+	for _, f := range i.Files {
+		if f.Length > int64(i.PieceLength) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// files are opened by the first piece in them and closed once the pieces
+// are past them, so only the files of a piece are open at once
+type verifier struct {
+	info    *Info
+	rootDir string
+	files   map[int]*os.File
+}
+
+func (v *verifier) close() {
+
+	v.release(len(v.info.Files))
+}
+
+// closes the files before the file j
+func (v *verifier) release(j int) {
+
+	for k, f := range v.files {
+		if k < j {
+			f.Close()
+			delete(v.files, k)
+		}
+	}
+}
+
+func (v *verifier) path(j int) string {
+
+	return filepath.Join(v.rootDir, v.info.Files[j].Path)
+}
+
+func (v *verifier) size(j int) int64 {
+
+	if v.info.Files[j].IsPadding() {
+		return v.info.Files[j].Length
+	}
+
+	stat, err := os.Stat(v.path(j))
+	if err != nil || !stat.Mode().IsRegular() {
+		return -1
+	}
+
+	return stat.Size()
+}
+
+// missing or unreadable files and short reads make the piece bad, padding
+// files are zeros and don't have to exist
+func (v *verifier) read(span FileSpan, buf []byte) bool {
+
+	if v.info.Files[span.File].IsPadding() {
+		for j := range buf[:span.Length] {
+			buf[j] = 0
+		}
+		return true
+	}
+
+	// failed opens aren't kept, the next piece tries again
+	f, opened := v.files[span.File]
+	if !opened {
+		var err error
+		f, err = os.Open(v.path(span.File))
+		if err != nil {
+			return false
+		}
+		v.files[span.File] = f
+	}
+
+	_, err := f.ReadAt(buf[:span.Length], span.Offset)
+
+	return err == nil
+}
+
+func (v *verifier) checkPiece(n int, spans []FileSpan) bool {
+
+	var data []byte
+	for _, span := range spans {
+		buf := make([]byte, span.Length)
+		if !v.read(span, buf) {
+			return false
+		}
+		data = append(data, buf...)
+	}
+
+	if v.info.HasV1() {
+		hash := sha1.Sum(data) // nolint: gosec
+		return bytes.Equal(hash[:], v.info.PieceHash(n))
+	}
+
+	return v.checkPieceV2(n, spans[0], data)
+}
+
+// v2 piece hashes are merkle roots of 16 KiB blocks, the piece layer holds
+// them for files larger than a piece, smaller files are checked by the root
+func (v *verifier) checkPieceV2(n int, span FileSpan, data []byte) bool {
+
+	f := v.info.Files[span.File]
+	pieceLength := int64(v.info.PieceLength)
+
+	if f.Length <= pieceLength {
+		leaves := 1
+		for int64(leaves)*blockSize < f.Length {
+			leaves *= 2
+		}
+		root := merkleRoot(blockHashes(data), leaves)
+		return bytes.Equal(root[:], f.PiecesRoot[:])
+	}
+
+	expected := v.info.PieceHash(n)
+	if expected == nil {
+		return false
+	}
+
+	root := merkleRoot(blockHashes(data), int(pieceLength/blockSize))
+
+	return bytes.Equal(root[:], expected)
+}
+
+func blockHashes(data []byte) [][32]byte {
+
+	var hashes [][32]byte
+	for len(data) > 0 {
+		block := data
+		if len(block) > blockSize {
+			block = block[:blockSize]
+		}
+		hashes = append(hashes, sha256.Sum256(block))
+		data = data[len(block):]
+	}
+
+	return hashes
+}
+
+// root of the tree with leaves padded by zero hashes to the leaves count
+func merkleRoot(hashes [][32]byte, leaves int) [32]byte {
+
+	layer := make([][32]byte, leaves)
+	copy(layer, hashes)
+
+	for len(layer) > 1 {
+		next := make([][32]byte, len(layer)/2)
+		for j := range next {
+			pair := append(layer[2*j][:], layer[2*j+1][:]...)
+			next[j] = sha256.Sum256(pair)
+		}
+		layer = next
+	}
+
+	return layer[0]
+}
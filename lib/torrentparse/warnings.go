@@ -0,0 +1,218 @@
+package torrentparse
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+)
+
+// kinds of warnings, stable for logs and statistics
+const (
+	WarnNonCanonical = bencode.IssueNonCanonical
+	WarnUnsortedKeys = bencode.IssueUnsortedKeys
+	WarnDuplicateKey = bencode.IssueDuplicateKey
+	WarnUnknownKey   = "unknown-key"
+	WarnPadding      = "padding"
+	WarnEmptyName    = "empty-name"
+	WarnInvalidUTF8  = "invalid-utf8"
+	WarnUnsafePath   = "unsafe-path"
+)
+
+// torrents made by broken clients can produce a warning for every file
+const maxWarnings = 100
+
+// defect tolerated in lenient mode, Field and Offset are like in ParseError
+type Warning struct {
+	Kind   string
+	Field  string
+	Offset int64
+	Detail string
+}
+
+func (w Warning) String() string {
+
+	var b strings.Builder
+
+	b.WriteString(w.Kind)
+	if w.Field != "" {
+		fmt.Fprintf(&b, ": field %q", w.Field)
+	}
+	if w.Offset >= 0 {
+		fmt.Fprintf(&b, " at offset %d", w.Offset)
+	}
+	if w.Detail != "" {
+		b.WriteString(": ")
+		b.WriteString(w.Detail)
+	}
+
+	return b.String()
+}
+
+// keys defined by BEPs or written by common clients
+var (
+	knownMetaKeys = keySet("info", "piece layers", "announce", "announce-list",
+		"url-list", "httpseeds", "nodes", "creation date", "comment",
+		"comment.utf-8", "created by", "created by.utf-8", "encoding",
+		"publisher", "publisher.utf-8", "publisher-url", "publisher-url.utf-8")
+	knownInfoKeys = keySet("piece length", "pieces", "name", "name.utf-8",
+		"length", "md5sum", "files", "meta version", "file tree", "private",
+		"source", "attr", "sha1", "crc32", "md5", "mtime", "collections",
+		"similar", "publisher", "publisher.utf-8", "publisher-url",
+		"publisher-url.utf-8")
+	knownFileKeys = keySet("length", "path", "path.utf-8", "md5sum", "attr",
+		"symlink path", "sha1", "crc32", "md5", "mtime", "ed2k", "filehash")
+)
+
+func keySet(keys ...string) map[string]bool {
+
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[key] = true
+	}
+
+	return set
+}
+
+type warnings []Warning
+
+func (ws *warnings) add(kind, field string, offset int64, detail string) {
+
+	if len(*ws) < maxWarnings {
+		*ws = append(*ws, Warning{Kind: kind, Field: field, Offset: offset, Detail: detail})
+	}
+}
+
+// fills in offsets of the fields like locateError from the offsets of the
+// dict keys
+func (ws warnings) locate(offsets map[string]int64) {
+
+	for j := range ws {
+		if ws[j].Offset >= 0 {
+			continue
+		}
+		key := strings.SplitN(ws[j].Field, "/", 2)[0]
+		if offset, exists := offsets[key]; exists {
+			ws[j].Offset = offset
+		}
+	}
+}
+
+// warnings about the info dictionary b, decoded into v and parsed into i
+func infoWarnings(b []byte, v *bencode.Value, i *Info, ib *infoDict) []Warning {
+
+	var ws warnings
+
+	issueWarnings(&ws, v.Validate())
+	if v.End < int64(len(b)) {
+		ws.add(WarnNonCanonical, "", v.End, "data after the end")
+	}
+	unknownKeys(&ws, v, 0, "", knownInfoKeys)
+	if files := v.Get("files"); files != nil {
+		for j, f := range files.List {
+			unknownKeys(&ws, f, 0, fmt.Sprintf("files/%d/", j), knownFileKeys)
+		}
+	}
+
+	contentWarnings(&ws, i, ib)
+
+	return ws
+}
+
+// warnings about the decoded values of the info dictionary
+func contentWarnings(ws *warnings, i *Info, ib *infoDict) {
+
+	name, files := ib.Name, ib.Files
+
+	if name == "" {
+		ws.add(WarnEmptyName, "name", -1, "replaced with "+i.Name)
+	} else if !utf8.ValidString(name) {
+		ws.add(WarnInvalidUTF8, "name", -1, fmt.Sprintf("%q", name))
+	}
+	for j, f := range files {
+		for _, p := range f.Path {
+			if !utf8.ValidString(p) {
+				ws.add(WarnInvalidUTF8, fmt.Sprintf("files/%d/path", j), -1,
+					fmt.Sprintf("%q", p))
+				break
+			}
+		}
+	}
+
+	paddingWarnings(ws, i, files)
+
+	ws.locate(i.keys)
+}
+
+// warnings about the metainfo file followed by the warnings of its info
+// dictionary at infoOffset
+func metaWarnings(ws warnings, info *Info, infoOffset int64) []Warning {
+
+	for _, w := range info.Warnings {
+		w.Field = joinField("info", w.Field)
+		if w.Offset >= 0 {
+			w.Offset += infoOffset
+		}
+		ws.add(w.Kind, w.Field, w.Offset, w.Detail)
+	}
+
+	return ws
+}
+
+// BEP 47 padding files should only align the start of the following file
+// to the piece boundary
+func paddingWarnings(ws *warnings, i *Info, files []file) {
+
+	pieceLength := int64(i.PieceLength)
+
+	var offset int64
+	for j, f := range files {
+		offset += f.Length
+		if !isPadding(f) {
+			continue
+		}
+
+		field := fmt.Sprintf("files/%d", j)
+		switch {
+		case j == len(files)-1:
+			ws.add(WarnPadding, field, -1, "padding file at the end")
+		case f.Length >= pieceLength:
+			ws.add(WarnPadding, field, -1,
+				fmt.Sprintf("%d bytes of padding for %d bytes pieces", f.Length, pieceLength))
+		case offset%pieceLength != 0:
+			ws.add(WarnPadding, field, -1, "next file is not aligned to a piece")
+		}
+	}
+}
+
+// keys of the dict v missing from known, in the order of the data, base is
+// the offset of the data v was decoded from
+func unknownKeys(ws *warnings, v *bencode.Value, base int64, prefix string, known map[string]bool) {
+
+	seen := make(map[string]bool)
+	for _, pair := range v.Dict {
+		if known[pair.Key] || seen[pair.Key] {
+			continue
+		}
+		seen[pair.Key] = true
+		ws.add(WarnUnknownKey, prefix+pair.Key, base+pair.Value.Start, "")
+	}
+}
+
+// adds the issues found by the bencode package
+func issueWarnings(ws *warnings, issues []bencode.Issue) {
+
+	for _, is := range issues {
+		ws.add(is.Kind, is.Path, is.Offset, is.Detail)
+	}
+}
+
+func joinField(field, key string) string {
+
+	if field == "" {
+		return key
+	}
+
+	return field + "/" + key
+}
@@ -0,0 +1,165 @@
+package torrentstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// torrent files by infohash, in <dir>/<hash[:2]>/<hash[2:4]>/<hash>.torrent
+// or <hash>.torrent.gz for compressed ones
+//
+// A torrent is only written once, putting it again does nothing whether it
+// was compressed or not. Files are written through temporary files so a
+// torrent is either whole or missing, and are durable after Sync.
+type Archive struct {
+	dir      string
+	compress bool
+	unsynced map[string]bool // dirs with new torrents
+}
+
+const (
+	archiveExt     = ".torrent"
+	archiveGzipExt = ".torrent.gz"
+)
+
+// opens the archive in dir, which is created by the first Put, new
+// torrents are compressed with gzip when compress is set
+func OpenArchive(dir string, compress bool) (*Archive, error) {
+
+	stat, err := os.Stat(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil && !stat.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", dir)
+	}
+
+	return &Archive{dir: dir, compress: compress, unsynced: make(map[string]bool)}, nil
+}
+
+func (a *Archive) shard(hash string) string {
+
+	return filepath.Join(a.dir, hash[:2], hash[2:4])
+}
+
+// path of the archived torrent of the hash, false if it isn't archived
+func (a *Archive) Path(hash string) (string, bool, error) {
+
+	hash = strings.ToLower(hash)
+	if _, err := keyOf(hash); err != nil {
+		return "", false, err
+	}
+
+	for _, ext := range []string{archiveExt, archiveGzipExt} {
+		path := filepath.Join(a.shard(hash), hash+ext)
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, true, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", false, err
+		}
+	}
+
+	return "", false, nil
+}
+
+// archives the torrent file of the hash unless it's archived already,
+// the hash isn't checked against the torrent
+func (a *Archive) Put(hash string, torrent []byte) (bool, error) {
+
+	hash = strings.ToLower(hash)
+	if _, archived, err := a.Path(hash); archived || err != nil {
+		return false, err
+	}
+
+	shard := a.shard(hash)
+	if err := os.MkdirAll(shard, 0755); err != nil {
+		return false, err
+	}
+
+	tmp, err := os.CreateTemp(shard, "."+hash+"-")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	path := filepath.Join(shard, hash+archiveExt)
+	if a.compress {
+		path = filepath.Join(shard, hash+archiveGzipExt)
+		z := gzip.NewWriter(tmp)
+		_, err = z.Write(torrent)
+		if closeErr := z.Close(); err == nil {
+			err = closeErr
+		}
+	} else {
+		_, err = tmp.Write(torrent)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return false, err
+	}
+	a.unsynced[shard] = true
+
+	return true, nil
+}
+
+// torrent file of the hash, an error satisfying os.IsNotExist is returned
+// if it isn't archived
+func (a *Archive) Get(hash string) ([]byte, error) {
+
+	path, archived, err := a.Path(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !archived {
+		return nil, &os.PathError{Op: "get", Path: filepath.Join(a.shard(hash), hash+archiveExt),
+			Err: os.ErrNotExist}
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil || !strings.HasSuffix(path, archiveGzipExt) {
+		return b, err
+	}
+
+	z, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+
+	return io.ReadAll(z)
+}
+
+// makes the torrents put since the last Sync durable
+func (a *Archive) Sync() error {
+
+	for shard := range a.unsynced {
+		// new shards are entries of their parent dirs too
+		for _, dir := range []string{shard, filepath.Dir(shard), a.dir, filepath.Dir(a.dir)} {
+			if err := SyncDir(dir); err != nil {
+				return err
+			}
+		}
+		delete(a.unsynced, shard)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package torrentstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// torrents are read back as put, compressed or not, and only written once
+func TestArchive(t *testing.T) {
+
+	dir := filepath.Join(t.TempDir(), "archive")
+
+	for _, compress := range []bool{false, true} {
+		a, err := OpenArchive(dir, compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hash := testRecord(1).Hash
+		if compress {
+			hash = testRecord(2).Hash
+		}
+		torrent := []byte("d4:infod4:name" + hash + "ee")
+
+		for _, want := range []bool{true, false} {
+			if stored, err := a.Put(hash, torrent); err != nil || stored != want {
+				t.Errorf("compress %v: put: %v %v, want %v", compress, stored, err, want)
+			}
+		}
+		if err := a.Sync(); err != nil {
+			t.Fatal(err)
+		}
+
+		path, archived, err := a.Path(strings.ToUpper(hash))
+		if err != nil || !archived {
+			t.Fatalf("compress %v: path: %v %v", compress, archived, err)
+		}
+		want := filepath.Join(dir, hash[:2], hash[2:4], hash+".torrent")
+		if compress {
+			want += ".gz"
+		}
+		if path != want {
+			t.Errorf("path %s, want %s", path, want)
+		}
+
+		b, err := a.Get(hash)
+		if err != nil || !bytes.Equal(b, torrent) {
+			t.Errorf("compress %v: get: %q %v", compress, b, err)
+		}
+	}
+
+	// torrents archived uncompressed aren't compressed again
+	a, err := OpenArchive(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := a.Put(testRecord(1).Hash, []byte("d4:infodee")); stored || err != nil {
+		t.Errorf("put again: %v %v", stored, err)
+	}
+
+	if _, err := a.Get(testRecord(3).Hash); !os.IsNotExist(err) {
+		t.Errorf("get of a torrent never put: %v", err)
+	}
+	if _, err := a.Put("../../x", nil); err == nil {
+		t.Error("put with an invalid hash")
+	}
+}
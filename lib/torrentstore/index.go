@@ -0,0 +1,616 @@
+package torrentstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+)
+
+// torrents.idx is a header and entries sorted by hash, all big-endian:
+//
+//	magic [8]byte, generation uint64, log size uint64, count uint64
+//	hash [20]byte, offset uint64
+//
+// Offsets are of the current records in torrents.db, whose generation and
+// committed size at the time the index was written are in the header.
+const (
+	indexMagic      = "TSIDX\x00\x00\x01"
+	indexHeaderSize = 32
+	indexEntrySize  = 28
+)
+
+// returned for an index that doesn't belong to the log anymore, the log
+// was rewritten or truncated since it was written
+var ErrStaleIndex = errors.New("stale index, rebuild it with torrentdb index")
+
+type indexKey [20]byte
+
+type indexEntry struct {
+	key    indexKey
+	offset int64
+}
+
+// hash → offset index of a log, read from the file on every lookup
+type Index struct {
+	f          *os.File
+	generation uint64
+	logSize    int64
+	count      int64
+}
+
+func keyOf(hash string) (indexKey, error) {
+
+	var key indexKey
+	if len(hash) != 2*len(key) {
+		return key, fmt.Errorf("invalid hash %q", hash)
+	}
+	if _, err := hex.Decode(key[:], []byte(hash)); err != nil {
+		return key, fmt.Errorf("invalid hash %q: %w", hash, err)
+	}
+
+	return key, nil
+}
+
+func OpenIndex(path string) (*Index, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var header [indexHeaderSize]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if string(header[:8]) != indexMagic {
+		f.Close()
+		return nil, fmt.Errorf("%s: not an index", path)
+	}
+
+	x := &Index{
+		f:          f,
+		generation: binary.BigEndian.Uint64(header[8:]),
+		logSize:    int64(binary.BigEndian.Uint64(header[16:])),
+		count:      int64(binary.BigEndian.Uint64(header[24:])),
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if stat.Size() != indexHeaderSize+x.count*indexEntrySize {
+		f.Close()
+		return nil, fmt.Errorf("%s: size %d for %d entries", path, stat.Size(), x.count)
+	}
+
+	return x, nil
+}
+
+func (x *Index) Len() int {
+
+	return int(x.count)
+}
+
+func (x *Index) entry(i int64) (indexEntry, error) {
+
+	var b [indexEntrySize]byte
+	if _, err := x.f.ReadAt(b[:], indexHeaderSize+i*indexEntrySize); err != nil {
+		return indexEntry{}, err
+	}
+
+	var e indexEntry
+	copy(e.key[:], b[:20])
+	e.offset = int64(binary.BigEndian.Uint64(b[20:]))
+
+	return e, nil
+}
+
+// offset of the record in the log, by binary search
+func (x *Index) Lookup(hash string) (int64, bool, error) {
+
+	key, err := keyOf(hash)
+	if err != nil {
+		return 0, false, err
+	}
+
+	lo, hi := int64(0), x.count
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		e, err := x.entry(mid)
+		if err != nil {
+			return 0, false, err
+		}
+		switch bytes.Compare(e.key[:], key[:]) {
+		case 0:
+			return e.offset, true, nil
+		case -1:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+
+	return 0, false, nil
+}
+
+// offsets of the entries in the log, sorted
+func (x *Index) offsets() ([]int64, error) {
+
+	offsets := make([]int64, 0, x.count)
+	r := bufio.NewReader(io.NewSectionReader(x.f, indexHeaderSize, x.count*indexEntrySize))
+	for n := int64(0); n < x.count; n++ {
+		var b [indexEntrySize]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, int64(binary.BigEndian.Uint64(b[20:])))
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] < offsets[j]
+	})
+
+	return offsets, nil
+}
+
+func (x *Index) Close() error {
+
+	return x.f.Close()
+}
+
+// writes the index of the committed records
+//
+// An index of the same generation is updated by merging it with the
+// records written after it, any other index is replaced by a new one.
+func (l *Log) WriteIndex(path string) error {
+
+	if l.readOnly {
+		return ErrReadOnly
+	}
+	if l.pending > 0 {
+		return errors.New("index of uncommitted records")
+	}
+
+	old, err := OpenIndex(path)
+	if err == nil && (old.generation != l.generation || old.logSize > l.size) {
+		old.Close()
+		old = nil
+	}
+	if err != nil {
+		old = nil
+	}
+
+	var since int64
+	if old != nil {
+		defer old.Close()
+		since = old.logSize
+	}
+
+	// records written after the old index
+	var delta []indexEntry
+	for i, offset := range l.offsets {
+		if offset < since {
+			continue
+		}
+		key, err := keyOf(l.records[i].Hash)
+		if err != nil {
+			return err
+		}
+		delta = append(delta, indexEntry{key, offset})
+	}
+	if old != nil && len(delta) == 0 && old.logSize == l.size {
+		return nil
+	}
+	sort.Slice(delta, func(i, j int) bool {
+		return bytes.Compare(delta[i].key[:], delta[j].key[:]) < 0
+	})
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".torrentstore-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = l.mergeIndex(tmp, old, delta)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	return SyncDir(filepath.Dir(path))
+}
+
+// writes the entries of the old index and of the delta in order, those of
+// the delta replace old ones with the same hash
+func (l *Log) mergeIndex(f *os.File, old *Index, delta []indexEntry) error {
+
+	if _, err := f.Seek(indexHeaderSize, io.SeekStart); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+
+	var count uint64
+	write := func(e indexEntry) error {
+		var b [indexEntrySize]byte
+		copy(b[:20], e.key[:])
+		binary.BigEndian.PutUint64(b[20:], uint64(e.offset))
+		count++
+		_, err := w.Write(b[:])
+		return err
+	}
+
+	var r *bufio.Reader
+	var oldCount int64
+	if old != nil {
+		r = bufio.NewReader(io.NewSectionReader(old.f, indexHeaderSize, old.count*indexEntrySize))
+		oldCount = old.count
+	}
+
+	d := 0
+	for i := int64(0); i < oldCount; i++ {
+		var b [indexEntrySize]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return err
+		}
+		var e indexEntry
+		copy(e.key[:], b[:20])
+		e.offset = int64(binary.BigEndian.Uint64(b[20:]))
+
+		for d < len(delta) && bytes.Compare(delta[d].key[:], e.key[:]) < 0 {
+			if err := write(delta[d]); err != nil {
+				return err
+			}
+			d++
+		}
+		if d < len(delta) && delta[d].key == e.key {
+			e = delta[d]
+			d++
+		}
+		if err := write(e); err != nil {
+			return err
+		}
+	}
+	for ; d < len(delta); d++ {
+		if err := write(delta[d]); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	var header [indexHeaderSize]byte
+	copy(header[:8], indexMagic)
+	binary.BigEndian.PutUint64(header[8:], l.generation)
+	binary.BigEndian.PutUint64(header[16:], uint64(l.size))
+	binary.BigEndian.PutUint64(header[24:], count)
+	_, err := f.WriteAt(header[:], 0)
+
+	return err
+}
+
+// checks that the index has the committed records of the log at their
+// offsets, ErrStaleIndex is returned for an index of an older log
+func (l *Log) CheckIndex(path string) error {
+
+	x, err := OpenIndex(path)
+	if err != nil {
+		return err
+	}
+	defer x.Close()
+
+	if x.generation != l.generation || x.logSize != l.committed {
+		return ErrStaleIndex
+	}
+	if x.Len() != l.Len() {
+		return fmt.Errorf("%s: %d entries for %d records", path, x.Len(), l.Len())
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(x.f, indexHeaderSize, x.count*indexEntrySize))
+	var prev indexKey
+	for n := int64(0); n < x.count; n++ {
+		var b [indexEntrySize]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return err
+		}
+		var key indexKey
+		copy(key[:], b[:20])
+		offset := int64(binary.BigEndian.Uint64(b[20:]))
+
+		if n > 0 && bytes.Compare(prev[:], key[:]) >= 0 {
+			return fmt.Errorf("%s: entry %d out of order", path, n)
+		}
+		prev = key
+
+		i, exists := l.index[hex.EncodeToString(key[:])]
+		if !exists || l.offsets[i] != offset {
+			return fmt.Errorf("%s: entry %d: %x at offset %d doesn't match the log",
+				path, n, key, offset)
+		}
+	}
+
+	return nil
+}
+
+// opens the torrents.idx and the torrents.db of the database dir, returns
+// the size of the log or ErrStaleIndex when the index doesn't match it
+func openIndexed(dir string) (*Index, *os.File, int64, error) {
+
+	x, err := OpenIndex(filepath.Join(dir, "torrents.idx"))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	f, err := os.Open(filepath.Join(dir, "torrents.db"))
+	if err != nil {
+		x.Close()
+		return nil, nil, 0, err
+	}
+
+	stat, err := f.Stat()
+	if err == nil {
+		var generation uint64
+		generation, err = readGeneration(f)
+		if err == nil && (generation != x.generation || stat.Size() < x.logSize) {
+			err = ErrStaleIndex
+		}
+	}
+	if err != nil {
+		f.Close()
+		x.Close()
+		return nil, nil, 0, err
+	}
+
+	return x, f, stat.Size(), nil
+}
+
+// record of the hash in the torrents.db of the database dir, found with
+// its torrents.idx without loading the log
+//
+// Records committed after the index was written are read from the end of
+// the log. ErrStaleIndex is returned when the index doesn't match the log.
+func LookupRecord(dir, hash string) (Record, bool, error) {
+
+	x, f, size, err := openIndexed(dir)
+	if err != nil {
+		return Record{}, false, err
+	}
+	defer x.Close()
+	defer f.Close()
+
+	var rec Record
+	offset, found, err := x.Lookup(hash)
+	if err != nil {
+		return Record{}, false, err
+	}
+	if found {
+		rec, err = readRecordAt(f, offset)
+		if err != nil || rec.Hash != hash {
+			return Record{}, false, ErrStaleIndex
+		}
+	}
+
+	// committed after the index
+	r := bufio.NewReader(io.NewSectionReader(f, x.logSize, size-x.logSize))
+	err = eachCommitted(r, hash, func(p Record) {
+		rec, found = p, true
+	})
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	return rec, found, nil
+}
+
+// calls fn for the records in the torrents.db of the database dir, read
+// one at a time at the offsets of its torrents.idx without loading the log
+//
+// Records are in the order they were last put. ErrStaleIndex is returned
+// before any record when the index doesn't match the log.
+func ScanRecords(dir string, fn func(Record) error) error {
+
+	x, f, size, err := openIndexed(dir)
+	if err != nil {
+		return err
+	}
+	defer x.Close()
+	defer f.Close()
+
+	offsets, err := x.offsets()
+	if err != nil {
+		return err
+	}
+
+	// committed after the index, they replace the indexed versions
+	tail := newTable()
+	r := bufio.NewReader(io.NewSectionReader(f, x.logSize, size-x.logSize))
+	if err := eachCommitted(r, "", tail.apply); err != nil {
+		return err
+	}
+
+	r = bufio.NewReader(io.NewSectionReader(f, 0, x.logSize))
+	var offset int64
+	for len(offsets) > 0 {
+		kind, payload, err := readEntry(r)
+		if err != nil {
+			return fmt.Errorf("%s: offset %d: %w", f.Name(), offset, err)
+		}
+		start := offset
+		offset += int64(headerSize + len(payload))
+
+		if start < offsets[0] {
+			continue
+		}
+		if start > offsets[0] || kind != entryRecord {
+			return fmt.Errorf("%s: no record at offset %d", f.Name(), offsets[0])
+		}
+		offsets = offsets[1:]
+
+		var rec Record
+		if err := bencode.Unmarshal(payload[1:], &rec); err != nil {
+			return fmt.Errorf("%s: offset %d: %w", f.Name(), start, err)
+		}
+		if _, updated := tail.index[rec.Hash]; updated {
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	return tail.Each(fn)
+}
+
+// record of the hash in the database dir, read one record at a time from
+// its torrents.db, or its torrents.tsv for databases made before the log,
+// for lookups without a usable index
+func FindRecord(dir, hash string) (Record, bool, error) {
+
+	var rec Record
+	found := false
+
+	f, err := os.Open(filepath.Join(dir, "torrents.db"))
+	if os.IsNotExist(err) {
+		return findTSVRecord(filepath.Join(dir, "torrents.tsv"), hash)
+	}
+	if err != nil {
+		return rec, found, err
+	}
+	defer f.Close()
+
+	err = eachCommitted(bufio.NewReader(f), hash, func(p Record) {
+		rec, found = p, true
+	})
+	if err != nil {
+		return Record{}, false, fmt.Errorf("%s: %w", f.Name(), err)
+	}
+
+	return rec, found, nil
+}
+
+func findTSVRecord(path, hash string) (Record, bool, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Record{}, false, err
+	}
+	defer f.Close()
+
+	var rec Record
+	found := false
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		n++
+		if !strings.HasPrefix(scanner.Text(), hash) {
+			continue
+		}
+		r, err := ParseLine(scanner.Text())
+		if err != nil {
+			return Record{}, false, fmt.Errorf("%s: line %d: %w", path, n, err)
+		}
+		if r.Hash == hash {
+			rec, found = r, true
+		}
+	}
+
+	return rec, found, scanner.Err()
+}
+
+// calls fn for the records of the hash, or all of them when it's empty,
+// once their commit mark is read, entries after the last mark are ignored
+func eachCommitted(r io.Reader, hash string, fn func(Record)) error {
+
+	var pending []Record
+	var offset int64
+	for {
+		kind, payload, err := readEntry(r)
+		if err == io.EOF || errors.Is(err, errTorn) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start := offset
+		offset += int64(headerSize + len(payload))
+
+		switch kind {
+		case entryHeader:
+		case entryRecord:
+			var p Record
+			if err := bencode.Unmarshal(payload[1:], &p); err != nil {
+				return fmt.Errorf("offset %d: %w", start, err)
+			}
+			if hash == "" || p.Hash == hash {
+				pending = append(pending, p)
+			}
+		case entryCommit:
+			for _, p := range pending {
+				fn(p)
+			}
+			pending = pending[:0]
+		default:
+			return fmt.Errorf("offset %d: unknown entry %q", start, kind)
+		}
+	}
+}
+
+// generation of the log, 0 for logs without a header
+func readGeneration(f *os.File) (uint64, error) {
+
+	kind, payload, err := readEntry(io.NewSectionReader(f, 0, maxEntrySize+headerSize))
+	if err == io.EOF || errors.Is(err, errTorn) || (err == nil && kind != entryHeader) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var h logHeader
+	if err := bencode.Unmarshal(payload[1:], &h); err != nil {
+		return 0, err
+	}
+
+	return h.Generation, nil
+}
+
+func readRecordAt(f *os.File, offset int64) (Record, error) {
+
+	var rec Record
+	kind, payload, err := readEntry(io.NewSectionReader(f, offset, maxEntrySize+headerSize))
+	if err != nil {
+		return rec, err
+	}
+	if kind != entryRecord {
+		return rec, fmt.Errorf("no record at offset %d", offset)
+	}
+
+	err = bencode.Unmarshal(payload[1:], &rec)
+
+	return rec, err
+}
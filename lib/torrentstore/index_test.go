@@ -0,0 +1,201 @@
+package torrentstore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func lookup(t *testing.T, dir string, n int) (Record, bool) {
+
+	r, found, err := LookupRecord(dir, testRecord(n).Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r, found
+}
+
+// updates are merged into the index, records committed after it are read
+// from the log
+func TestIndex(t *testing.T) {
+
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, "torrents.idx")
+
+	l := openTestLog(t, filepath.Join(dir, "torrents.db"))
+	defer l.Close()
+	for n := 0; n < 100; n += 2 {
+		l.Put(testRecord(n))
+	}
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.WriteIndex(indexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// odd records are new, every fourth is updated
+	for n := 1; n < 100; n += 2 {
+		l.Put(testRecord(n))
+	}
+	for n := 0; n < 100; n += 4 {
+		r := testRecord(n)
+		r.Hits = 2
+		l.Put(r)
+	}
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if r, found := lookup(t, dir, 3); !found || r != testRecord(3) {
+		t.Errorf("record after the index: %v %+v", found, r)
+	}
+	if r, _ := lookup(t, dir, 4); r.Hits != 2 {
+		t.Errorf("update after the index: %+v", r)
+	}
+
+	if err := l.WriteIndex(indexPath); err != nil {
+		t.Fatal(err)
+	}
+	x, err := OpenIndex(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	if x.Len() != 100 || x.logSize != l.size {
+		t.Errorf("%d entries up to %d, want 100 up to %d", x.Len(), x.logSize, l.size)
+	}
+
+	for n := 0; n < 100; n++ {
+		want := testRecord(n)
+		if n%4 == 0 {
+			want.Hits = 2
+		}
+		if r, found := lookup(t, dir, n); !found || r != want {
+			t.Errorf("%d: %v %+v, want %+v", n, found, r, want)
+		}
+	}
+	if _, found := lookup(t, dir, 100); found {
+		t.Error("found a record never put")
+	}
+}
+
+// rewriting the log makes the index stale until it's written again
+func TestIndexCompact(t *testing.T) {
+
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, "torrents.idx")
+
+	l := openTestLog(t, filepath.Join(dir, "torrents.db"))
+	defer l.Close()
+	for n := 0; n < 10; n++ {
+		l.Put(testRecord(n))
+	}
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.WriteIndex(indexPath); err != nil {
+		t.Fatal(err)
+	}
+	generation := l.generation
+
+	for hits := 2; l.generation == generation; hits++ {
+		for n := 0; n < 10; n++ {
+			r := testRecord(n)
+			r.Hits = hits
+			l.Put(r)
+		}
+		if err := l.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := LookupRecord(dir, testRecord(0).Hash); err != ErrStaleIndex {
+		t.Errorf("lookup after rewriting the log: %v", err)
+	}
+
+	if err := l.WriteIndex(indexPath); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := l.Get(testRecord(5).Hash)
+	if r, found := lookup(t, dir, 5); !found || r != want {
+		t.Errorf("%v %+v, want %+v", found, r, want)
+	}
+
+	if err := os.Remove(indexPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := LookupRecord(dir, want.Hash); !os.IsNotExist(err) {
+		t.Errorf("lookup without index: %v", err)
+	}
+}
+
+// scans read the current records with the index and lookups without it
+// read the log through, both without loading the log
+func TestScanRecords(t *testing.T) {
+
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, "torrents.idx")
+
+	l := openTestLog(t, filepath.Join(dir, "torrents.db"))
+	defer l.Close()
+	for n := 0; n < 10; n++ {
+		l.Put(testRecord(n))
+	}
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.WriteIndex(indexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// updated and new records after the index, and uncommitted ones
+	for n := 0; n < 10; n += 3 {
+		r := testRecord(n)
+		r.Hits = 2
+		l.Put(r)
+	}
+	l.Put(testRecord(10))
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	l.Put(testRecord(11))
+	if err := l.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := make(map[string]Record)
+	for _, r := range records(t, l) {
+		if r.Hash != testRecord(11).Hash {
+			want[r.Hash] = r
+		}
+	}
+
+	got := make(map[string]Record)
+	err := ScanRecords(dir, func(r Record) error {
+		if _, exists := got[r.Hash]; exists {
+			t.Errorf("%s read twice", r.Hash)
+		}
+		got[r.Hash] = r
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("scan: %v\n%v\nwant %v", err, got, want)
+	}
+
+	for n := 0; n < 12; n++ {
+		r, found, err := FindRecord(dir, testRecord(n).Hash)
+		if err != nil || found != (n < 11) || r != want[testRecord(n).Hash] {
+			t.Errorf("find %d: %v %+v %v", n, found, r, err)
+		}
+	}
+
+	if err := os.Remove(indexPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := ScanRecords(dir, nil); !os.IsNotExist(err) {
+		t.Errorf("scan without index: %v", err)
+	}
+}
@@ -0,0 +1,470 @@
+package torrentstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/torrentdb/torrent_utils/lib/bencode"
+)
+
+// kinds of log entries
+const (
+	entryHeader = 'h'
+	entryRecord = 'r'
+	entryCommit = 'c'
+)
+
+// entries are framed by the payload length and its CRC-32C
+const (
+	headerSize   = 8
+	maxEntrySize = 1 << 24
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// returned when an entry is damaged but committed entries follow it, the
+// damage can't be the end of a write that was cut short
+var ErrCorrupt = errors.New("corrupt entry before committed entries")
+
+// first entry of the file, the generation changes when the file is
+// rewritten so indexes of the old file can be told apart
+type logHeader struct {
+	Generation uint64 `bencode:"generation"`
+}
+
+// Store keeping the records in an append-only file
+//
+// Every Put appends the new version of the record and Commit appends a
+// commit mark and syncs the file. Entries after the last commit mark, left
+// by a run that died or a torn write, are dropped when the log is opened.
+// A damaged entry followed by a commit mark is ErrCorrupt instead, nothing
+// committed is ever dropped. The file is rewritten without old versions
+// when they are the majority.
+type Log struct {
+	table
+	path       string
+	f          *os.File
+	w          *bufio.Writer
+	readOnly   bool
+	generation uint64
+	offsets    []int64 // of the records in the file
+	entries    int     // records in the file, old versions included
+	size       int64   // written to the file
+	committed  int64   // size up to the last commit
+	pending    int     // records put since the last commit
+	dropped    int64   // uncommitted bytes truncated by OpenLog
+}
+
+// opens the log for reading and writing, creating it if needed
+func OpenLog(path string) (*Log, error) {
+
+	return openLog(path, false)
+}
+
+// opens the log for reading, uncommitted entries are ignored
+func OpenLogReadOnly(path string) (*Log, error) {
+
+	return openLog(path, true)
+}
+
+func openLog(path string, readOnly bool) (*Log, error) {
+
+	var f *os.File
+	var err error
+	if readOnly {
+		f, err = os.Open(path)
+	} else {
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		path:     path,
+		f:        f,
+		table:    newTable(),
+		readOnly: readOnly,
+	}
+
+	if err := l.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if readOnly {
+		return l, nil
+	}
+
+	// drop what follows the last commit
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.dropped = stat.Size() - l.size
+	if err := f.Truncate(l.size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(l.size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.w = bufio.NewWriter(f)
+
+	if l.size == 0 {
+		l.generation = 1
+		n, err := writeHeader(l.w, l.generation)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		l.size = int64(n)
+		l.committed = l.size
+	}
+
+	return l, nil
+}
+
+// reads the committed records, size is left at the end of the last commit
+func (l *Log) load() error {
+
+	r := bufio.NewReader(l.f)
+
+	type pendingRecord struct {
+		Record
+		offset int64
+	}
+
+	var pending []pendingRecord
+	var offset int64
+	for {
+		kind, payload, err := readEntry(r)
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, errTorn) {
+			return l.checkTail(offset)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: offset %d: %w", l.path, offset, err)
+		}
+		start := offset
+		offset += int64(headerSize + len(payload))
+
+		switch kind {
+		case entryHeader:
+			if start != 0 {
+				return fmt.Errorf("%s: offset %d: header after the start", l.path, start)
+			}
+			var h logHeader
+			if err := bencode.Unmarshal(payload[1:], &h); err != nil {
+				return fmt.Errorf("%s: offset %d: %w", l.path, start, err)
+			}
+			l.generation = h.Generation
+			l.size = offset
+			l.committed = offset
+
+		case entryRecord:
+			var rec Record
+			if err := bencode.Unmarshal(payload[1:], &rec); err != nil {
+				return fmt.Errorf("%s: offset %d: %w", l.path, start, err)
+			}
+			pending = append(pending, pendingRecord{rec, start})
+
+		case entryCommit:
+			for _, p := range pending {
+				l.apply(p.Record, p.offset)
+			}
+			l.entries += len(pending)
+			pending = pending[:0]
+			l.size = offset
+			l.committed = offset
+
+		default:
+			return fmt.Errorf("%s: offset %d: unknown entry %q", l.path, start, kind)
+		}
+	}
+}
+
+// checks that there's no commit mark after the damaged entry at offset,
+// which is then the end of a write that was cut short
+func (l *Log) checkTail(offset int64) error {
+
+	var mark bytes.Buffer
+	if _, err := writeEntry(&mark, entryCommit, nil); err != nil {
+		return err
+	}
+
+	// the entry at offset is skipped
+	start := offset + 1 // of chunk in the file
+	buf := make([]byte, 1<<16)
+	var chunk []byte
+	for {
+		n, err := l.f.ReadAt(buf, start+int64(len(chunk)))
+		chunk = append(chunk, buf[:n]...)
+		if i := bytes.Index(chunk, mark.Bytes()); i >= 0 {
+			return fmt.Errorf("%s: offset %d: %w at offset %d",
+				l.path, offset, ErrCorrupt, start+int64(i))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// keep what could be the start of a mark
+		keep := mark.Len() - 1
+		if keep > len(chunk) {
+			keep = len(chunk)
+		}
+		start += int64(len(chunk) - keep)
+		chunk = append(chunk[:0], chunk[len(chunk)-keep:]...)
+	}
+}
+
+// entry that was cut short or doesn't match its checksum
+var errTorn = errors.New("torn entry")
+
+// returns the kind and the payload starting with it
+func readEntry(r io.Reader) (byte, []byte, error) {
+
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, errTorn
+		}
+		return 0, nil, err
+	}
+
+	n := binary.BigEndian.Uint32(header[:4])
+	if n == 0 || n > maxEntrySize {
+		return 0, nil, errTorn
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, errTorn
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+		return 0, nil, errTorn
+	}
+
+	return payload[0], payload, nil
+}
+
+func writeEntry(w io.Writer, kind byte, body []byte) (int, error) {
+
+	payload := append([]byte{kind}, body...)
+
+	var header [headerSize]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:], crc32.Checksum(payload, crcTable))
+
+	if _, err := w.Write(header[:]); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return 0, err
+	}
+
+	return headerSize + len(payload), nil
+}
+
+func writeHeader(w io.Writer, generation uint64) (int, error) {
+
+	body, err := bencode.Marshal(logHeader{Generation: generation})
+	if err != nil {
+		return 0, err
+	}
+
+	return writeEntry(w, entryHeader, body)
+}
+
+func writeRecord(w io.Writer, r Record) (int, error) {
+
+	body, err := bencode.Marshal(r)
+	if err != nil {
+		return 0, err
+	}
+
+	return writeEntry(w, entryRecord, body)
+}
+
+func (l *Log) Put(r Record) error {
+
+	if l.readOnly {
+		return ErrReadOnly
+	}
+	if r.Hash == "" {
+		return errors.New("record without hash")
+	}
+
+	n, err := writeRecord(l.w, r)
+	if err != nil {
+		return err
+	}
+	l.apply(r, l.size)
+	l.size += int64(n)
+	l.entries++
+	l.pending++
+
+	return nil
+}
+
+func (l *Log) apply(r Record, offset int64) {
+
+	l.table.apply(r)
+	i := l.index[r.Hash]
+	if i == len(l.offsets) {
+		l.offsets = append(l.offsets, offset)
+		return
+	}
+	l.offsets[i] = offset
+}
+
+// makes the records put so far durable
+func (l *Log) Commit() error {
+
+	if l.readOnly {
+		return ErrReadOnly
+	}
+	if l.pending == 0 {
+		return nil
+	}
+
+	n, err := writeEntry(l.w, entryCommit, nil)
+	if err != nil {
+		return err
+	}
+	l.size += int64(n)
+
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.pending = 0
+	l.committed = l.size
+
+	if l.entries > 2*len(l.records) {
+		return l.compact()
+	}
+
+	return nil
+}
+
+// rewrites the log with the current versions through a temporary file
+func (l *Log) compact() error {
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), ".torrentstore-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	generation := l.generation + 1
+	n, err := writeHeader(w, generation)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	size := int64(n)
+	offsets := make([]int64, len(l.records))
+	for i, r := range l.records {
+		offsets[i] = size
+		n, err := writeRecord(w, r)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		size += int64(n)
+	}
+	n, err = writeEntry(w, entryCommit, nil)
+	size += int64(n)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return err
+	}
+	if err := SyncDir(filepath.Dir(l.path)); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f.Close()
+	l.f = f
+	l.w = bufio.NewWriter(f)
+	l.size = size
+	l.committed = size
+	l.entries = len(l.records)
+	l.generation = generation
+	l.offsets = offsets
+
+	return nil
+}
+
+// generation and size of the log up to the last commit, they change with
+// every Commit of put records
+func (l *Log) Committed() (uint64, int64) {
+
+	return l.generation, l.committed
+}
+
+// size of the entries after the last commit mark that OpenLog dropped,
+// left by a run that died before committing
+func (l *Log) Dropped() int64 {
+
+	return l.dropped
+}
+
+// closes the file, records put after the last Commit are lost
+func (l *Log) Close() error {
+
+	return l.f.Close()
+}
+
+// SyncDir makes renames and new files in the directory durable
+func SyncDir(dir string) error {
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
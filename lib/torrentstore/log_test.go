@@ -0,0 +1,242 @@
+package torrentstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testRecord(n int) Record {
+
+	return Record{
+		Hash:      fmt.Sprintf("%040x", n),
+		Size:      int64(n) * 1000,
+		Files:     n,
+		FirstSeen: "2002-05-14",
+		LastSeen:  "2002-05-14",
+		Hits:      1,
+		Name:      fmt.Sprint("torrent ", n),
+	}
+}
+
+func records(t *testing.T, s Store) []Record {
+
+	var rs []Record
+	err := s.Each(func(r Record) error {
+		rs = append(rs, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs
+}
+
+func openTestLog(t *testing.T, path string) *Log {
+
+	l, err := OpenLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+// only committed records survive reopening, in the order first put
+func TestLogCommit(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "torrents.db")
+
+	l := openTestLog(t, path)
+	for n := 0; n < 3; n++ {
+		if err := l.Put(testRecord(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	updated := testRecord(0)
+	updated.Hits = 2
+	if err := l.Put(updated); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := l.Get(updated.Hash); r.Hits != 2 {
+		t.Errorf("uncommitted put not visible: %+v", r)
+	}
+	l.Close()
+
+	l = openTestLog(t, path)
+	want := []Record{testRecord(0), testRecord(1), testRecord(2)}
+	if got := records(t, l); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if err := l.Put(updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l = openTestLog(t, path)
+	defer l.Close()
+	want[0] = updated
+	if got := records(t, l); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// a write cut short is dropped and the log can be appended to again
+func TestLogTornWrite(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "torrents.db")
+
+	l := openTestLog(t, path)
+	l.Put(testRecord(0))
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	l.Put(testRecord(1))
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, stat.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	ro, err := OpenLogReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ro.Len() != 1 {
+		t.Errorf("read-only: %d records, want 1", ro.Len())
+	}
+	if err := ro.Put(testRecord(2)); err != ErrReadOnly {
+		t.Errorf("read-only put: %v", err)
+	}
+	ro.Close()
+
+	l = openTestLog(t, path)
+	if l.Dropped() == 0 {
+		t.Error("torn entry not reported as dropped")
+	}
+	l.Put(testRecord(2))
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l = openTestLog(t, path)
+	defer l.Close()
+	want := []Record{testRecord(0), testRecord(2)}
+	if got := records(t, l); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// damage before committed entries isn't taken for a torn write, the file
+// is left as it is
+func TestLogCorrupt(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "torrents.db")
+
+	l := openTestLog(t, path)
+	for n := 0; n < 3; n++ {
+		l.Put(testRecord(n))
+	}
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a byte of the first record, after the header entry
+	_, header, err := readEntry(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[headerSize+len(header)+headerSize+5] ^= 0xff
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenLog(path); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("open: %v, want ErrCorrupt", err)
+	}
+	if _, err := OpenLogReadOnly(path); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("read-only open: %v, want ErrCorrupt", err)
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != int64(len(b)) {
+		t.Errorf("size %d after opening, want %d", stat.Size(), len(b))
+	}
+}
+
+// old versions are dropped from the file, the order is kept
+func TestLogCompact(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "torrents.db")
+
+	l := openTestLog(t, path)
+	var want []Record
+	for n := 0; n < 10; n++ {
+		want = append(want, testRecord(n))
+		l.Put(testRecord(n))
+	}
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for hits := 2; hits < 5; hits++ {
+		for n := range want {
+			want[n].Hits = hits
+			l.Put(want[n])
+		}
+		if err := l.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l.entries > 2*l.Len() {
+		t.Errorf("%d entries for %d records", l.entries, l.Len())
+	}
+	l.Close()
+
+	l = openTestLog(t, path)
+	defer l.Close()
+	if got := records(t, l); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestLine(t *testing.T) {
+
+	r := testRecord(7)
+	r.Size = 123456789012345678 // wider than the padding
+	r.Hits = 100000
+
+	got, err := ParseLine(FormatLine(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != r {
+		t.Errorf("got %+v, want %+v", got, r)
+	}
+}
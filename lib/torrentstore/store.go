@@ -0,0 +1,180 @@
+// Package torrentstore keeps the torrent records of a torrentdb database.
+//
+// A Record is a line of torrents.tsv keyed by infohash. Log stores them in
+// an append-only file that survives crashes, TSV reads a torrents.tsv of
+// databases made before the log. An Index of the log finds a record on
+// disk without loading the log, and ScanRecords reads the records one at a
+// time with it. FormatLine and ParseLine convert records
+// to and from torrents.tsv lines. An Archive keeps the torrent files of the
+// records by infohash.
+package torrentstore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// returned by Put of stores opened read-only
+var ErrReadOnly = errors.New("store is read-only")
+
+// torrent in the database, seen dates are YYYY-MM-DD
+type Record struct {
+	Hash      string `bencode:"hash"`
+	Size      int64  `bencode:"size"`
+	Files     int    `bencode:"files"`
+	FirstSeen string `bencode:"first_seen"`
+	LastSeen  string `bencode:"last_seen"`
+	Hits      int    `bencode:"hits"`
+	Name      string `bencode:"name"`
+}
+
+// records keyed by hash, in the order they were first put
+//
+// Put is visible to Get right away but only durable after Commit.
+type Store interface {
+	Get(hash string) (Record, bool)
+	Put(r Record) error
+	Len() int
+	Each(fn func(Record) error) error
+	Commit() error
+	Close() error
+}
+
+// records in memory, in the order they were first put
+type table struct {
+	records []Record
+	index   map[string]int
+}
+
+func newTable() table {
+
+	return table{index: make(map[string]int)}
+}
+
+func (t *table) Get(hash string) (Record, bool) {
+
+	i, exists := t.index[hash]
+	if !exists {
+		return Record{}, false
+	}
+
+	return t.records[i], true
+}
+
+func (t *table) Len() int {
+
+	return len(t.records)
+}
+
+// calls fn for the records in the order they were first put
+func (t *table) Each(fn func(Record) error) error {
+
+	for _, r := range t.records {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *table) apply(r Record) {
+
+	if i, exists := t.index[r.Hash]; exists {
+		t.records[i] = r
+		return
+	}
+
+	t.index[r.Hash] = len(t.records)
+	t.records = append(t.records, r)
+}
+
+// torrents.tsv line of the record, numbers are padded to fixed widths but
+// wider values are written in full
+func FormatLine(r Record) string {
+
+	return fmt.Sprintf("%s\t%14d\t%11d\t%s\t%s\t%5d\t%s",
+		r.Hash,
+		r.Size,
+		r.Files,
+		r.FirstSeen,
+		r.LastSeen,
+		r.Hits,
+		r.Name)
+}
+
+// record of a torrents.tsv line
+func ParseLine(l string) (Record, error) {
+
+	var r Record
+	var err error
+
+	ll := strings.SplitN(l, "\t", 7)
+	if len(ll) != 7 {
+		return r, fmt.Errorf("%d fields instead of 7: %q", len(ll), l)
+	}
+
+	r.Hash = ll[0]
+
+	r.Size, err = strconv.ParseInt(strings.TrimSpace(ll[1]), 10, 64)
+	if err != nil {
+		return r, err
+	}
+
+	r.Files, err = strconv.Atoi(strings.TrimSpace(ll[2]))
+	if err != nil {
+		return r, err
+	}
+
+	r.FirstSeen = strings.TrimSpace(ll[3])
+	r.LastSeen = strings.TrimSpace(ll[4])
+
+	r.Hits, err = strconv.Atoi(strings.TrimSpace(ll[5]))
+	if err != nil {
+		return r, err
+	}
+
+	r.Name = strings.TrimSpace(ll[6])
+
+	return r, nil
+}
+
+// writes the records as torrents.tsv
+func WriteTSV(w io.Writer, s Store) error {
+
+	bw := bufio.NewWriter(w)
+
+	err := s.Each(func(r Record) error {
+		_, err := fmt.Fprintln(bw, FormatLine(r))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// puts the records of a torrents.tsv, returns how many lines were read
+func ImportTSV(r io.Reader, s Store) (int, error) {
+
+	n := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		n++
+		rec, err := ParseLine(scanner.Text())
+		if err != nil {
+			return n, fmt.Errorf("line %d: %w", n, err)
+		}
+		if err := s.Put(rec); err != nil {
+			return n, err
+		}
+	}
+
+	return n, scanner.Err()
+}
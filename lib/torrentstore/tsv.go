@@ -0,0 +1,65 @@
+package torrentstore
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// opens the torrents.db log of the database dir for reading, or its
+// torrents.tsv for databases made before the log
+func OpenReadOnly(dir string) (Store, error) {
+
+	l, err := OpenLogReadOnly(filepath.Join(dir, "torrents.db"))
+	if os.IsNotExist(err) {
+		return OpenTSV(filepath.Join(dir, "torrents.tsv"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// read-only Store of a torrents.tsv, for databases made before the log
+type TSV struct {
+	table
+	readOnly bool // set once the file is loaded
+}
+
+func OpenTSV(path string) (*TSV, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &TSV{table: newTable()}
+	if _, err := ImportTSV(f, t); err != nil {
+		return nil, err
+	}
+	t.readOnly = true
+
+	return t, nil
+}
+
+// only used while loading the file
+func (t *TSV) Put(r Record) error {
+
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	t.apply(r)
+
+	return nil
+}
+
+func (t *TSV) Commit() error {
+
+	return ErrReadOnly
+}
+
+func (t *TSV) Close() error {
+
+	return nil
+}